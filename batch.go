@@ -0,0 +1,456 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"image"
+	"log/slog"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fogleman/primitive/primitive"
+)
+
+// batchConfig is the parameter matrix for a batch run. Every combination
+// of Counts, Alphas and Modes is run on every input image.
+type batchConfig struct {
+	Counts     []int  `json:"n"`
+	Alphas     []int  `json:"a"`
+	Modes      []int  `json:"m"`
+	Repeat     int    `json:"rep"`
+	InputSize  int    `json:"r"`
+	Filter     string `json:"filter"`
+	OutputSize int    `json:"s"`
+	Workers    int    `json:"j"`
+	Jobs       int    `json:"jobs"`
+	Nth        int    `json:"nth"`
+	Layout     string `json:"layout"`
+	Scene      bool   `json:"scene"`
+}
+
+// intList is a comma separated list of integers.
+type intList []int
+
+func (l *intList) String() string {
+	var s []string
+	for _, x := range *l {
+		s = append(s, strconv.Itoa(x))
+	}
+	return strings.Join(s, ",")
+}
+
+func (l *intList) Set(value string) error {
+	var result []int
+	for _, s := range strings.Split(value, ",") {
+		x, err := strconv.Atoi(strings.TrimSpace(s))
+		if err != nil {
+			return fmt.Errorf("invalid number: %q", s)
+		}
+		result = append(result, x)
+	}
+	*l = result
+	return nil
+}
+
+type batchJob struct {
+	Input  *batchInput
+	Count  int
+	Alpha  int
+	Mode   int
+	Root   string
+	Output string
+}
+
+// batchInput is an input image shared by all the jobs that use it. It is
+// decoded and resized once, when the first of those jobs starts, and
+// released when the last one finishes.
+type batchInput struct {
+	Path      string
+	Name      string
+	once      sync.Once
+	image     image.Image
+	err       error
+	remaining int
+	mu        sync.Mutex
+}
+
+// load reads the input once for all of its jobs, downscaled to size unless
+// it is 0 or InputSizeAuto, which Run resolves per job.
+func (in *batchInput) load(size int, filter primitive.Filter) (image.Image, error) {
+	in.once.Do(func() {
+		in.image, in.err = primitive.LoadImage(in.Path)
+		if in.err == nil && size > 0 {
+			in.image = primitive.Downscale(in.image, size, filter)
+		}
+	})
+	return in.image, in.err
+}
+
+func (in *batchInput) release() {
+	in.mu.Lock()
+	defer in.mu.Unlock()
+	in.remaining--
+	if in.remaining == 0 {
+		in.image = nil
+	}
+}
+
+var batchExtensions = map[string]bool{
+	".jpg": true, ".jpeg": true, ".png": true, ".gif": true,
+	".bmp": true, ".tif": true, ".tiff": true, ".webp": true,
+}
+
+// batchInputs expands a directory or glob into the image files it names.
+func batchInputs(pattern string) ([]string, error) {
+	if info, err := os.Stat(pattern); err == nil && info.IsDir() {
+		entries, err := os.ReadDir(pattern)
+		if err != nil {
+			return nil, err
+		}
+		var paths []string
+		for _, entry := range entries {
+			ext := strings.ToLower(filepath.Ext(entry.Name()))
+			if !entry.IsDir() && batchExtensions[ext] {
+				paths = append(paths, filepath.Join(pattern, entry.Name()))
+			}
+		}
+		return paths, nil
+	}
+	paths, err := filepath.Glob(pattern)
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(paths)
+	return paths, nil
+}
+
+// batchPath fills in the {image}, {m}, {n}, {a} and {rep} placeholders of
+// an output layout.
+func batchPath(layout, name string, m, n, a, rep int) string {
+	return strings.NewReplacer(
+		"{image}", name,
+		"{m}", strconv.Itoa(m),
+		"{n}", strconv.Itoa(n),
+		"{a}", strconv.Itoa(a),
+		"{rep}", strconv.Itoa(rep),
+	).Replace(layout)
+}
+
+// batchFramePath is batchPath for the frame'th frame of a job. The frame
+// number goes at {frame}, or before the extension when the layout has no
+// {frame}, so frames never take the place of a final output.
+func batchFramePath(layout, name string, m, n, a, rep, frame int) string {
+	if !strings.Contains(layout, "{frame}") {
+		ext := filepath.Ext(layout)
+		layout = strings.TrimSuffix(layout, ext) + ".{frame}" + ext
+	}
+	layout = strings.ReplaceAll(layout, "{frame}", strconv.Itoa(frame))
+	return batchPath(layout, name, m, n, a, rep)
+}
+
+func batch(args []string) {
+	config := batchConfig{
+		Counts:     []int{500},
+		Alphas:     []int{128},
+		Modes:      []int{0, 1, 3, 5},
+		InputSize:  128,
+		OutputSize: 512,
+		Jobs:       4,
+		Layout:     "{image}/{m}.{n}.png",
+		Scene:      true,
+	}
+
+	flags := flag.NewFlagSet("batch", flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage: primitive batch [OPTIONS] input output")
+		fmt.Fprintln(os.Stderr, "input is a directory or a glob pattern; output is the output directory")
+		flags.PrintDefaults()
+	}
+	configPath := flags.String("config", "", "JSON file with the parameter matrix, overridden by flags")
+	var counts, alphas, modes intList
+	flags.Var(&counts, "n", "comma separated numbers of primitives (default 500)")
+	flags.Var(&alphas, "a", "comma separated alpha values (default 128)")
+	flags.Var(&modes, "m", "comma separated modes (default 0,1,3,5)")
+	repeat := flags.Int("rep", 0, "add N extra shapes per iteration with reduced search")
+	inputSize := flags.Int("r", 0, "resize large input images to this size, or -1 to pick it from the number of shapes (default 128)")
+	filter := flags.String("filter", "", "resampling filter for -r: bilinear, area, lanczos or mitchell (default bilinear)")
+	outputSize := flags.Int("s", 0, "output image size (default 512)")
+	workers := flags.Int("j", 0, "total number of workers shared by running jobs (default uses all cores)")
+	jobs := flags.Int("jobs", 0, "number of images to process at once (default 4)")
+	nth := flags.Int("nth", 0, "also save every Nth frame, at {frame} in the layout or before its extension")
+	layout := flags.String("layout", "", "output path relative to the output directory (default \"{image}/{m}.{n}.png\")")
+	scene := flags.Bool("scene", true, "write a JSON scene with the shapes and run metadata next to each output")
+	verbose := flags.Bool("v", false, "verbose")
+	flags.Parse(args)
+
+	level := slog.LevelWarn
+	if *verbose {
+		level = slog.LevelInfo
+	}
+	slog.SetDefault(slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: level})))
+
+	if *configPath != "" {
+		data, err := os.ReadFile(*configPath)
+		check(err)
+		check(json.Unmarshal(data, &config))
+	}
+	flags.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "n":
+			config.Counts = counts
+		case "a":
+			config.Alphas = alphas
+		case "m":
+			config.Modes = modes
+		case "rep":
+			config.Repeat = *repeat
+		case "r":
+			config.InputSize = *inputSize
+		case "filter":
+			config.Filter = *filter
+		case "s":
+			config.OutputSize = *outputSize
+		case "j":
+			config.Workers = *workers
+		case "jobs":
+			config.Jobs = *jobs
+		case "nth":
+			config.Nth = *nth
+		case "layout":
+			config.Layout = *layout
+		case "scene":
+			config.Scene = *scene
+		}
+	})
+
+	// validate arguments
+	ok := true
+	if flags.NArg() != 2 {
+		ok = errorMessage("ERROR: input and output arguments required")
+	}
+	// check each value of n, m and a in a stage of its own
+	probe := primitive.Options{
+		InputSize:  config.InputSize,
+		OutputSize: config.OutputSize,
+		Workers:    config.Workers,
+	}
+	for _, n := range config.Counts {
+		probe.Stages = append(probe.Stages, primitive.Stage{Count: n, Mode: 1, Alpha: 128, Repeat: config.Repeat})
+	}
+	for _, m := range config.Modes {
+		probe.Stages = append(probe.Stages, primitive.Stage{Count: 1, Mode: primitive.ShapeType(m), Alpha: 128})
+	}
+	for _, a := range config.Alphas {
+		probe.Stages = append(probe.Stages, primitive.Stage{Count: 1, Mode: 1, Alpha: a})
+	}
+	if f, err := primitive.ParseFilter(config.Filter); err != nil {
+		ok = validationMessages(err, "", true)
+	} else {
+		probe.Filter = f
+	}
+	reported := make(map[string]bool)
+	for _, e := range primitive.ValidationErrors(probe.Validate()) {
+		e.Stage = 0
+		if e.Field != "stages" && !reported[e.Error()] {
+			reported[e.Error()] = true
+			ok = validationMessages(e, "", true)
+		}
+	}
+	if len(config.Counts) == 0 || len(config.Alphas) == 0 || len(config.Modes) == 0 {
+		ok = errorMessage("ERROR: n, a and m need at least one value")
+	}
+	if _, found := primitive.FormatForPath(config.Layout); !found {
+		ok = errorMessage("ERROR: layout must end in a known file extension")
+	}
+	axes := []struct {
+		name   string
+		values []int
+	}{{"{m}", config.Modes}, {"{n}", config.Counts}, {"{a}", config.Alphas}}
+	for _, axis := range axes {
+		if len(axis.values) > 1 && !strings.Contains(config.Layout, axis.name) {
+			ok = errorMessage("ERROR: layout must contain " + axis.name + " when it has several values")
+		}
+	}
+	if !ok {
+		flags.Usage()
+		os.Exit(1)
+	}
+	outputDir := flags.Arg(1)
+
+	paths, err := batchInputs(flags.Arg(0))
+	check(err)
+	if len(paths) == 0 {
+		check(fmt.Errorf("no input images found: %s", flags.Arg(0)))
+	}
+
+	// build the job list, skipping results that already exist
+	var queue []*batchJob
+	skipped := 0
+	for _, path := range paths {
+		name := strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+		input := &batchInput{Path: path, Name: name}
+		for _, m := range config.Modes {
+			for _, n := range config.Counts {
+				for _, a := range config.Alphas {
+					output := filepath.Join(outputDir,
+						batchPath(config.Layout, name, m, n, a, config.Repeat))
+					if _, err := os.Stat(output); err == nil {
+						skipped++
+						continue
+					}
+					input.remaining++
+					queue = append(queue, &batchJob{input, n, a, m, outputDir, output})
+				}
+			}
+		}
+	}
+	slog.Warn("batch", "images", len(paths), "jobs", len(queue), "skipped", skipped)
+
+	// share the CPUs between the concurrent jobs
+	if config.Workers < 1 {
+		config.Workers = runtime.NumCPU()
+	}
+	if config.Jobs < 1 {
+		config.Jobs = 1
+	}
+	perJob := maxInt(config.Workers/config.Jobs, 1)
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	jobsChan := make(chan *batchJob)
+	var wg sync.WaitGroup
+	var failed int
+	var mu sync.Mutex
+	for i := 0; i < config.Jobs; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for job := range jobsChan {
+				if err := runBatchJob(ctx, job, config, perJob); err != nil {
+					if ctx.Err() == nil {
+						slog.Error(err.Error(), "input", job.Input.Path, "output", job.Output)
+					}
+					mu.Lock()
+					failed++
+					mu.Unlock()
+				}
+				job.Input.release()
+			}
+		}()
+	}
+	for _, job := range queue {
+		if ctx.Err() != nil {
+			break
+		}
+		jobsChan <- job
+	}
+	close(jobsChan)
+	wg.Wait()
+
+	if ctx.Err() != nil {
+		slog.Warn("interrupted; run again to resume")
+		os.Exit(1)
+	}
+	if failed > 0 {
+		check(fmt.Errorf("%d of %d jobs failed", failed, len(queue)))
+	}
+}
+
+// runBatchJob renders one image for one parameter combination. The final
+// output is only written when the run completes, so an interrupted batch
+// resumes with the unfinished jobs.
+func runBatchJob(ctx context.Context, job *batchJob, config batchConfig, workers int) error {
+	filter, _ := primitive.ParseFilter(config.Filter) // checked up front
+	input, err := job.Input.load(config.InputSize, filter)
+	if err != nil {
+		return err
+	}
+	slog.Info("running", "input", job.Input.Path, "m", job.Mode, "n", job.Count, "a", job.Alpha)
+	start := time.Now()
+	options := primitive.Options{
+		Stages: []primitive.Stage{{
+			Count:  job.Count,
+			Mode:   primitive.ShapeType(job.Mode),
+			Alpha:  job.Alpha,
+			Repeat: config.Repeat,
+		}},
+		OutputSize: config.OutputSize,
+		Workers:    workers,
+	}
+	if config.InputSize == primitive.InputSizeAuto {
+		// the size depends on the job's shapes, so Run resizes the input
+		options.InputSize = primitive.InputSizeAuto
+		options.Filter = filter
+	}
+	var frameErr error
+	if config.Nth > 0 {
+		options.OnShape = func(e primitive.Event) {
+			if e.Frame%config.Nth != 0 || e.Frame >= job.Count || frameErr != nil {
+				return
+			}
+			path := filepath.Join(job.Root, batchFramePath(config.Layout,
+				job.Input.Name, job.Mode, job.Count, job.Alpha, config.Repeat, e.Frame))
+			frameErr = writeBatchOutput(e.Model, path)
+		}
+	}
+	model, err := primitive.Run(ctx, input, options)
+	if err != nil {
+		return err
+	}
+	if frameErr != nil {
+		return frameErr
+	}
+	if config.InputSize != primitive.InputSizeAuto {
+		model.Meta.InputSize = config.InputSize
+	}
+	model.Meta.Filter = filter
+	if path, err := filepath.Abs(job.Input.Path); err == nil {
+		model.Meta.Input = path
+	}
+	if ext := filepath.Ext(job.Output); config.Scene && ext != ".json" {
+		// the scene goes first since the image marks the job as done
+		if err := writeBatchOutput(model, strings.TrimSuffix(job.Output, ext)+".json"); err != nil {
+			return err
+		}
+	}
+	if err := writeBatchOutput(model, job.Output); err != nil {
+		return err
+	}
+	slog.Info("done", "output", job.Output, "score", model.Score, "t", time.Since(start).Seconds())
+	return nil
+}
+
+// writeBatchOutput writes through a temporary file so that a partial
+// output is never mistaken for a finished one when resuming.
+func writeBatchOutput(model *primitive.Model, path string) error {
+	format, _ := primitive.FormatForPath(path)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	temp := path + ".tmp"
+	file, err := os.Create(temp)
+	if err != nil {
+		return err
+	}
+	options := primitive.DefaultEncoderOptions()
+	if err := primitive.Encode(file, format, model, options); err != nil {
+		file.Close()
+		os.Remove(temp)
+		return err
+	}
+	if err := file.Close(); err != nil {
+		os.Remove(temp)
+		return err
+	}
+	return os.Rename(temp, path)
+}
@@ -2,7 +2,6 @@ import datetime
 import os
 import random
 import requests
-import subprocess
 import time
 import traceback
 import twitter
@@ -13,6 +12,9 @@ MENTION_RATE = 65
 INPUT_FOLDER = ''
 OUTPUT_FOLDER = ''
 
+PRIMITIVE_URL = 'http://localhost:8080'
+PRIMITIVE_TIMEOUT = 60 * 10
+
 FLICKR_API_KEY = None
 TWITTER_CONSUMER_KEY = None
 TWITTER_CONSUMER_SECRET = None
@@ -117,16 +119,31 @@ def download_photo(url, path):
     with open(path, 'wb') as fp:
         fp.write(r.content)
 
-def primitive(**kwargs):
-    args = []
-    for k, v in kwargs.items():
-        if v is None:
-            continue
-        args.append('-%s' % k)
-        args.append(str(v))
-    args = ' '.join(args)
-    cmd = 'primitive %s' % args
-    subprocess.call(cmd, shell=True)
+def primitive(i, o, **kwargs):
+    # runs a job on a `primitive serve` instance and saves the result to o
+    params = dict((k, v) for k, v in kwargs.items() if v is not None)
+    with open(i, 'rb') as fp:
+        r = requests.post(PRIMITIVE_URL + '/jobs', data=params,
+            files={'image': fp})
+    r.raise_for_status()
+    job = r.json()
+    url = '%s/jobs/%s' % (PRIMITIVE_URL, job['id'])
+    deadline = time.time() + PRIMITIVE_TIMEOUT
+    while job['status'] in ('queued', 'running'):
+        if time.time() > deadline:
+            requests.delete(url)
+            print 'job timed out:', job['id']
+            return
+        time.sleep(1)
+        job = requests.get(url).json()
+    if job['status'] != 'done':
+        print 'job %s: %s' % (job['status'], job.get('error', ''))
+        return
+    ext = os.path.splitext(o)[1] or '.png'
+    r = requests.get('%s/output%s' % (url, ext))
+    r.raise_for_status()
+    with open(o, 'wb') as fp:
+        fp.write(r.content)
 
 def twitter_api():
     return twitter.Api(
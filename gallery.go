@@ -0,0 +1,378 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/fogleman/primitive/primitive"
+)
+
+type galleryItem struct {
+	Name    string  `json:"name"`
+	Image   string  `json:"image"`
+	Mode    string  `json:"mode,omitempty"`
+	Shapes  int     `json:"shapes,omitempty"`
+	Score   float64 `json:"score,omitempty"`
+	Runtime float64 `json:"runtime,omitempty"`
+	Size    int64   `json:"size"`
+}
+
+type galleryGroup struct {
+	Name     string         `json:"name"`
+	Original string         `json:"original,omitempty"`
+	Items    []*galleryItem `json:"items"`
+}
+
+var galleryImages = map[string]bool{".png": true, ".jpg": true, ".jpeg": true, ".gif": true, ".svg": true}
+
+// galleryFile is an output found in the tree: an image, a scene or both
+// when they share a base name.
+type galleryFile struct {
+	Dir   string
+	Base  string
+	Image string
+	Scene string
+}
+
+func galleryFiles(root string) ([]*galleryFile, error) {
+	files := make(map[string]*galleryFile)
+	scenes := make(map[string]bool)
+	err := filepath.WalkDir(root, func(path string, d os.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		ext := strings.ToLower(filepath.Ext(path))
+		if ext != ".json" && !galleryImages[ext] {
+			return nil
+		}
+		key := strings.TrimSuffix(path, filepath.Ext(path))
+		file, ok := files[key]
+		if !ok {
+			file = &galleryFile{Dir: filepath.Dir(path), Base: filepath.Base(key)}
+			files[key] = file
+		}
+		if ext == ".json" {
+			file.Scene = path
+			scenes[file.Dir] = true
+		} else {
+			file.Image = path
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	// in directories with scenes, images without one are intermediate frames
+	var result []*galleryFile
+	for _, file := range files {
+		if file.Scene == "" && scenes[file.Dir] {
+			continue
+		}
+		result = append(result, file)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return filepath.Join(result[i].Dir, result[i].Base) < filepath.Join(result[j].Dir, result[j].Base)
+	})
+	return result, nil
+}
+
+// galleryURL returns a link to path relative to the page's directory.
+func galleryURL(path, dir string) string {
+	if abs, err := filepath.Abs(path); err == nil {
+		if rel, err := filepath.Rel(dir, abs); err == nil {
+			path = rel
+		}
+	}
+	return (&url.URL{Path: filepath.ToSlash(path)}).String()
+}
+
+func galleryOriginal(name, inputs string) string {
+	if inputs == "" {
+		return ""
+	}
+	for _, ext := range []string{".jpg", ".jpeg", ".png", ".gif", ".bmp", ".tif", ".tiff", ".webp"} {
+		path := filepath.Join(inputs, name+ext)
+		if _, err := os.Stat(path); err == nil {
+			return path
+		}
+	}
+	return ""
+}
+
+func gallery(args []string) {
+	flags := flag.NewFlagSet("gallery", flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage: primitive gallery [OPTIONS] path...")
+		fmt.Fprintln(os.Stderr, "paths are batch output directories or scene files")
+		flags.PrintDefaults()
+	}
+	output := flags.String("o", "gallery.html", "output HTML path, - for stdout")
+	inputs := flags.String("inputs", "", "directory with the original images, for outputs without a scene")
+	title := flags.String("title", "primitive", "page title")
+	verbose := flags.Bool("v", false, "verbose")
+	flags.Parse(args)
+
+	level := slog.LevelWarn
+	if *verbose {
+		level = slog.LevelInfo
+	}
+	slog.SetDefault(slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: level})))
+
+	if flags.NArg() == 0 {
+		errorMessage("ERROR: at least one path required")
+		flags.Usage()
+		os.Exit(1)
+	}
+
+	// links are relative to the page
+	pageDir, err := os.Getwd()
+	check(err)
+	if *output != "-" {
+		pageDir, err = filepath.Abs(filepath.Dir(*output))
+		check(err)
+	}
+
+	var files []*galleryFile
+	for _, path := range flags.Args() {
+		found, err := galleryFiles(path)
+		check(err)
+		files = append(files, found...)
+	}
+
+	groups := make(map[string]*galleryGroup)
+	var names []string
+	for _, file := range files {
+		item := &galleryItem{Name: file.Base}
+		name := filepath.Base(file.Dir)
+		original := ""
+		if file.Scene != "" {
+			scene, err := primitive.LoadScene(file.Scene)
+			if err != nil {
+				slog.Warn("skipping", "path", file.Scene, "error", err)
+				continue
+			}
+			slog.Info("reading", "path", file.Scene)
+			item.Shapes = len(scene.Shapes)
+			item.Score = scene.Score
+			item.Runtime = scene.Elapsed
+			var modes []string
+			for _, stage := range scene.Stages {
+				modes = append(modes, stage.Mode)
+			}
+			item.Mode = strings.Join(modes, "+")
+			if scene.Input != "" {
+				name = strings.TrimSuffix(filepath.Base(scene.Input), filepath.Ext(scene.Input))
+				if _, err := os.Stat(scene.Input); err == nil {
+					original = scene.Input
+				}
+			}
+			if file.Image == "" {
+				// draw scenes that have no rendered image
+				model, err := scene.Model(nil, 0, 1)
+				if err != nil {
+					slog.Warn("skipping", "path", file.Scene, "error", err)
+					continue
+				}
+				svg := model.SVG()
+				item.Image = "data:image/svg+xml;base64," + base64.StdEncoding.EncodeToString([]byte(svg))
+				item.Size = int64(len(svg))
+			}
+		}
+		if file.Image != "" {
+			item.Image = galleryURL(file.Image, pageDir)
+			if info, err := os.Stat(file.Image); err == nil {
+				item.Size = info.Size()
+			}
+		}
+		if original == "" {
+			original = galleryOriginal(name, *inputs)
+		}
+
+		group, ok := groups[name]
+		if !ok {
+			group = &galleryGroup{Name: name}
+			groups[name] = group
+			names = append(names, name)
+		}
+		if group.Original == "" && original != "" {
+			group.Original = galleryURL(original, pageDir)
+		}
+		group.Items = append(group.Items, item)
+	}
+	if len(names) == 0 {
+		check(fmt.Errorf("no outputs found"))
+	}
+	sort.Strings(names)
+	var result []*galleryGroup
+	for _, name := range names {
+		result = append(result, groups[name])
+	}
+
+	data, err := json.Marshal(result)
+	check(err)
+	// keep the data from closing the script element
+	payload := strings.ReplaceAll(string(data), "</", "<\\/")
+	page := strings.NewReplacer(
+		"{{TITLE}}", htmlEscaper.Replace(*title),
+		"{{DATA}}", payload,
+	).Replace(galleryPage)
+
+	if *output == "-" {
+		fmt.Print(page)
+		return
+	}
+	slog.Info("writing", "path", *output)
+	check(os.WriteFile(*output, []byte(page), 0644))
+}
+
+var htmlEscaper = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;", "\"", "&quot;")
+
+const galleryPage = `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>{{TITLE}}</title>
+<style>
+body { margin: 0; padding: 8px 16px; background: #222; color: #ddd; font: 14px sans-serif; }
+header { display: flex; align-items: baseline; gap: 16px; }
+h1 { font-size: 20px; font-weight: normal; }
+h2 { font-size: 16px; font-weight: normal; margin: 24px 0 8px; }
+.row { display: flex; flex-wrap: wrap; gap: 8px; }
+.card { width: 320px; background: #2c2c2c; }
+.card .view { position: relative; line-height: 0; }
+.card img { width: 100%; display: block; }
+.card .original { position: absolute; top: 0; left: 0; display: none; }
+.card .view:hover .original { display: block; }
+.card .split { position: absolute; top: 0; bottom: 0; width: 1px; background: #fff; display: none; }
+.card .view:hover .split { display: block; }
+.card .stats { padding: 6px 8px; line-height: 1.5; font-variant-numeric: tabular-nums; }
+.card .name { color: #fff; }
+.source .stats { color: #999; }
+</style>
+</head>
+<body>
+<header>
+<h1>{{TITLE}}</h1>
+<label>Sort by
+<select id="sort">
+<option value="score">score</option>
+<option value="shapes">shapes</option>
+<option value="runtime">runtime</option>
+<option value="size">file size</option>
+<option value="name">name</option>
+</select>
+</label>
+<span>Hover an image to compare it with the original.</span>
+</header>
+<div id="groups"></div>
+<script>
+var groups = {{DATA}};
+(function() {
+	var container = document.getElementById("groups");
+	var select = document.getElementById("sort");
+
+	function el(tag, className, text) {
+		var e = document.createElement(tag);
+		if (className) e.className = className;
+		if (text !== undefined) e.textContent = text;
+		return e;
+	}
+
+	function bytes(n) {
+		if (n < 1024) return n + " B";
+		if (n < 1024 * 1024) return (n / 1024).toFixed(1) + " KB";
+		return (n / 1024 / 1024).toFixed(1) + " MB";
+	}
+
+	function stats(item) {
+		var parts = [];
+		if (item.mode) parts.push(item.mode);
+		if (item.shapes) parts.push(item.shapes + " shapes");
+		if (item.score) parts.push("score " + item.score.toFixed(5));
+		if (item.runtime) parts.push(item.runtime.toFixed(1) + " s");
+		parts.push(bytes(item.size));
+		return parts.join(" · ");
+	}
+
+	// items without a scene have no score and sort last
+	var keys = {
+		score: function(a, b) {
+			if (!a.score || !b.score) return (a.score ? 0 : 1) - (b.score ? 0 : 1);
+			return a.score - b.score;
+		},
+		shapes: function(a, b) { return (a.shapes || 0) - (b.shapes || 0); },
+		runtime: function(a, b) { return (a.runtime || 0) - (b.runtime || 0); },
+		size: function(a, b) { return a.size - b.size; },
+		name: function(a, b) { return a.name < b.name ? -1 : a.name > b.name ? 1 : 0; }
+	};
+
+	function card(item, original) {
+		var c = el("div", "card");
+		var view = el("div", "view");
+		var img = el("img");
+		img.src = item.image;
+		img.loading = "lazy";
+		view.appendChild(img);
+		if (original) {
+			// the original covers the variant left of the pointer
+			var over = el("img", "original");
+			over.src = original;
+			var split = el("div", "split");
+			view.appendChild(over);
+			view.appendChild(split);
+			view.addEventListener("mousemove", function(e) {
+				var r = view.getBoundingClientRect();
+				var x = Math.max(0, Math.min(1, (e.clientX - r.left) / r.width));
+				over.style.clipPath = "inset(0 " + (100 - x * 100) + "% 0 0)";
+				split.style.left = (x * 100) + "%";
+			});
+		}
+		c.appendChild(view);
+		var s = el("div", "stats");
+		s.appendChild(el("div", "name", item.name));
+		s.appendChild(el("div", "", stats(item)));
+		c.appendChild(s);
+		return c;
+	}
+
+	function render() {
+		var key = keys[select.value];
+		container.innerHTML = "";
+		groups.forEach(function(group) {
+			container.appendChild(el("h2", "", group.name));
+			var row = el("div", "row");
+			if (group.original) {
+				var source = el("div", "card source");
+				var view = el("div", "view");
+				var img = el("img");
+				img.src = group.original;
+				view.appendChild(img);
+				source.appendChild(view);
+				var s = el("div", "stats");
+				s.appendChild(el("div", "name", "original"));
+				source.appendChild(s);
+				row.appendChild(source);
+			}
+			group.items.slice().sort(key).forEach(function(item) {
+				row.appendChild(card(item, group.original));
+			});
+			container.appendChild(row);
+		});
+	}
+
+	select.addEventListener("change", render);
+	render();
+})();
+</script>
+</body>
+</html>
+`
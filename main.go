@@ -1,34 +1,49 @@
 package main
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
-	"log"
-	"math/rand"
+	"log/slog"
 	"os"
+	"os/signal"
 	"path/filepath"
-	"runtime"
 	"strconv"
 	"strings"
-	"time"
 
 	"github.com/fogleman/primitive/primitive"
-	"github.com/nfnt/resize"
 )
 
 var (
-	Input      string
-	Outputs    flagArray
-	Background string
-	Configs    shapeConfigArray
-	Alpha      int
-	InputSize  int
-	OutputSize int
-	Mode       int
-	Workers    int
-	Nth        int
-	Repeat     int
-	V, VV      bool
+	Input       string
+	Outputs     flagArray
+	Background  string
+	Configs     shapeConfigArray
+	Alpha       int
+	MinAlpha    int
+	MaxAlpha    int
+	InputSize   int
+	OutputSize  int
+	Mode        int
+	Workers     int
+	Nth         int
+	Repeat      int
+	Format      string
+	Filter      string
+	Pyramid     int
+	Refine      int
+	RefineEvery int
+	Tile        int
+	Antialias   bool
+	Progress    string
+	Pipeline    string
+	Bed         string
+	Layers      string
+	Seed        int64
+	Encoding    primitive.EncoderOptions
+	V, VV       bool
 )
 
 type flagArray []string
@@ -56,39 +71,199 @@ func (i *shapeConfigArray) String() string {
 }
 
 func (i *shapeConfigArray) Set(value string) error {
-	n, _ := strconv.ParseInt(value, 0, 0)
+	n, err := strconv.ParseInt(value, 0, 0)
+	if err != nil {
+		return errors.New("must be a whole number")
+	}
 	*i = append(*i, shapeConfig{int(n), Mode, Alpha, Repeat})
 	return nil
 }
 
+// alphaFlag is an int flag that also accepts "auto".
+type alphaFlag int
+
+func (a *alphaFlag) String() string {
+	if *a == primitive.AlphaAuto {
+		return "auto"
+	}
+	return strconv.Itoa(int(*a))
+}
+
+func (a *alphaFlag) Set(value string) error {
+	if value == "auto" {
+		*a = primitive.AlphaAuto
+		return nil
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		return errors.New(`must be a whole number or "auto"`)
+	}
+	*a = alphaFlag(n)
+	return nil
+}
+
+// sizeFlag is an int flag that also accepts "auto".
+type sizeFlag int
+
+func (s *sizeFlag) String() string {
+	if *s == primitive.InputSizeAuto {
+		return "auto"
+	}
+	return strconv.Itoa(int(*s))
+}
+
+func (s *sizeFlag) Set(value string) error {
+	if value == "auto" {
+		*s = primitive.InputSizeAuto
+		return nil
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		return errors.New(`must be a whole number or "auto"`)
+	}
+	*s = sizeFlag(n)
+	return nil
+}
+
 func init() {
 	flag.StringVar(&Input, "i", "", "input image path")
 	flag.Var(&Outputs, "o", "output image path")
 	flag.Var(&Configs, "n", "number of primitives")
-	flag.StringVar(&Background, "bg", "", "background color (hex)")
-	flag.IntVar(&Alpha, "a", 128, "alpha value")
-	flag.IntVar(&InputSize, "r", 256, "resize large input images to this size")
+	flag.StringVar(&Background, "bg", "", "background color (hex), or none for a transparent canvas")
+	Alpha = 128
+	flag.Var((*alphaFlag)(&Alpha), "a", "alpha value, 0 to hill climb it or auto to fit it for each shape")
+	flag.IntVar(&MinAlpha, "min-alpha", 0, "lowest alpha chosen by -a auto (default 1)")
+	flag.IntVar(&MaxAlpha, "max-alpha", 0, "highest alpha chosen by -a auto (default 255)")
+	InputSize = 256
+	flag.Var((*sizeFlag)(&InputSize), "r", "resize large input images to this size, or auto to pick it from -n and the image detail")
+	flag.StringVar(&Filter, "filter", "bilinear", "resampling filter for -r: bilinear, area, lanczos or mitchell")
+	flag.IntVar(&Pyramid, "pyramid", 0, "search large shapes on up to N coarser copies of the target first")
+	flag.IntVar(&Refine, "refine", 0, "re-optimize all shapes in context this many times after the last one is added")
+	flag.IntVar(&RefineEvery, "refine-every", 0, "also re-optimize all shapes each time this many have been added")
+	flag.IntVar(&Tile, "tile", 0, "search large working images in overlapping tiles of this size (use with a large -r)")
+	flag.BoolVar(&Antialias, "aa", false, "score shapes with antialiased edges, as they are drawn in the output")
 	flag.IntVar(&OutputSize, "s", 1024, "output image size")
 	flag.IntVar(&Mode, "m", 1, "0=combo 1=triangle 2=rect 3=ellipse 4=circle 5=rotatedrect 6=beziers 7=rotatedellipse 8=polygon")
 	flag.IntVar(&Workers, "j", 0, "number of parallel workers (default uses all cores)")
 	flag.IntVar(&Nth, "nth", 1, "save every Nth frame (put \"%d\" in path)")
 	flag.IntVar(&Repeat, "rep", 0, "add N extra shapes per iteration with reduced search")
+	flag.Int64Var(&Seed, "seed", 0, "random seed (default uses the current time)")
+	flag.StringVar(&Pipeline, "config", "", "YAML or JSON pipeline file describing the stages (instead of -n)")
+	flag.StringVar(&Format, "format", "svg", "output format for \"-o -\" and paths without a known extension")
+	Encoding = primitive.DefaultEncoderOptions()
+	Plot := &Encoding.Plot
+	flag.IntVar(&Encoding.FPS, "fps", Encoding.FPS, "video frame rate (y4m output)")
+	flag.Float64Var(&Encoding.Hold, "hold", Encoding.Hold, "seconds to hold the last video frame (y4m output)")
+	flag.IntVar(&Encoding.VideoSize, "vs", 0, "video frame size (y4m output, default uses -s)")
+	flag.StringVar(&Layers, "layers", "shape", "layer grouping: shape, stage or type (ora output)")
+	flag.StringVar(&Bed, "bed", "200x200", "plotter bed size WxH (gcode/hpgl output)")
+	flag.StringVar(&Plot.Units, "units", Plot.Units, "plotter units: mm or in (gcode/hpgl output)")
+	flag.Float64Var(&Plot.Feed, "feed", Plot.Feed, "plotter feed rate in units per minute (gcode/hpgl output)")
+	flag.Float64Var(&Plot.HatchSpacing, "hatch", Plot.HatchSpacing, "minimum hatch spacing, 0 for outlines only (gcode/hpgl output)")
+	flag.IntVar(&Plot.Levels, "levels", Plot.Levels, "color levels per channel used to group pens and layers (gcode/hpgl/dxf output)")
+	flag.IntVar(&Plot.Pens, "pens", Plot.Pens, "most pens to use, drawing rarer colors with the nearest pen; 0 for one per color, at most 8 for hpgl (gcode/hpgl output)")
+	flag.BoolVar(&Plot.Optimize, "nn", Plot.Optimize, "order plotter paths by nearest neighbour (gcode/hpgl output)")
+	flag.Float64Var(&Encoding.Millimeters, "mm", Encoding.Millimeters, "physical size of the longest side in mm (dxf output)")
+	flag.StringVar(&Progress, "progress", "", "progress stream: json for one JSON line per shape")
 	flag.BoolVar(&V, "v", false, "verbose")
 	flag.BoolVar(&VV, "vv", false, "very verbose")
 }
 
+var layerGroupings = map[string]primitive.LayerGrouping{
+	"shape": primitive.LayerPerShape,
+	"stage": primitive.LayerPerStage,
+	"type":  primitive.LayerPerType,
+}
+
+// autoAlpha reports whether any -n uses -a auto.
+func autoAlpha() bool {
+	for _, config := range Configs {
+		if config.Alpha == primitive.AlphaAuto {
+			return true
+		}
+	}
+	return false
+}
+
 func errorMessage(message string) bool {
 	fmt.Fprintln(os.Stderr, message)
 	return false
 }
 
+// flagNames maps library option names to the flags that set them.
+var flagNames = map[string]string{
+	"count":        "n",
+	"shape":        "m",
+	"alpha":        "a",
+	"min_alpha":    "min-alpha",
+	"max_alpha":    "max-alpha",
+	"repeat":       "rep",
+	"input_size":   "r",
+	"output_size":  "s",
+	"workers":      "j",
+	"background":   "bg",
+	"filter":       "filter",
+	"pyramid":      "pyramid",
+	"refine":       "refine",
+	"refine_every": "refine-every",
+	"tile":         "tile",
+	"antialias":    "aa",
+	"max_loss":     "loss",
+	"strategy":     "strategy",
+	"output":       "o",
+}
+
+// validationMessages prints the errors in err with their hints and returns
+// false. Options that came from flags are named by their flag, and prefix
+// is put before each message.
+func validationMessages(err error, prefix string, flags bool) bool {
+	errs := primitive.ValidationErrors(err)
+	if len(errs) == 0 {
+		for _, line := range strings.Split(err.Error(), "\n") {
+			errorMessage("ERROR: " + prefix + strings.TrimPrefix(line, prefix))
+		}
+		return false
+	}
+	for _, e := range errs {
+		if name, ok := flagNames[e.Field]; ok && flags {
+			e.Field = "-" + name
+			if len(Configs) <= 1 {
+				e.Stage = 0
+			}
+		}
+		errorMessage("ERROR: " + prefix + e.Error())
+		if e.Hint != "" {
+			errorMessage("       " + e.Hint)
+		}
+	}
+	return false
+}
+
 func check(err error) {
 	if err != nil {
-		log.Fatal(err)
+		slog.Error(err.Error())
+		os.Exit(1)
 	}
 }
 
 func main() {
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "serve":
+			serve(os.Args[2:])
+			return
+		case "batch":
+			batch(os.Args[2:])
+			return
+		case "gallery":
+			gallery(os.Args[2:])
+			return
+		case "prune":
+			prune(os.Args[2:])
+			return
+		}
+	}
+
 	// parse and validate arguments
 	flag.Parse()
 	ok := true
@@ -98,110 +273,309 @@ func main() {
 	if len(Outputs) == 0 {
 		ok = errorMessage("ERROR: output argument required")
 	}
-	if len(Configs) == 0 {
+	if len(Configs) == 0 && Pipeline == "" {
 		ok = errorMessage("ERROR: number argument required")
 	}
+	if len(Configs) > 0 && Pipeline != "" {
+		ok = errorMessage("ERROR: use either -n or -config")
+	}
+	if Input != "" && Input != "-" {
+		if _, err := os.Stat(Input); err != nil {
+			ok = errorMessage("ERROR: cannot read input: " + err.Error())
+		}
+	}
 	if len(Configs) == 1 {
 		Configs[0].Mode = Mode
 		Configs[0].Alpha = Alpha
 		Configs[0].Repeat = Repeat
 	}
+
+	// check the run options given as flags
+	options := primitive.Options{
+		InputSize:   InputSize,
+		OutputSize:  OutputSize,
+		Workers:     Workers,
+		Seed:        Seed,
+		Pyramid:     Pyramid,
+		Refine:      Refine,
+		RefineEvery: RefineEvery,
+		Tile:        Tile,
+		Antialias:   Antialias,
+	}
+	background, err := primitive.ParseBackground(Background)
+	if err != nil {
+		ok = validationMessages(err, "", true)
+	}
+	options.Background = background
+	filter, err := primitive.ParseFilter(Filter)
+	if err != nil {
+		ok = validationMessages(err, "", true)
+	}
+	options.Filter = filter
 	for _, config := range Configs {
-		if config.Count < 1 {
-			ok = errorMessage("ERROR: number argument must be > 0")
+		stage := primitive.Stage{
+			Count:  config.Count,
+			Mode:   primitive.ShapeType(config.Mode),
+			Alpha:  config.Alpha,
+			Repeat: config.Repeat,
+		}
+		// the alpha range goes with every -a auto, and is reported as out
+		// of place if there is none
+		if config.Alpha == primitive.AlphaAuto || !autoAlpha() {
+			stage.MinAlpha, stage.MaxAlpha = MinAlpha, MaxAlpha
 		}
+		options.Stages = append(options.Stages, stage)
 	}
+	for _, e := range primitive.ValidationErrors(options.Validate()) {
+		if e.Field != "stages" { // reported above
+			ok = validationMessages(e, "", true)
+		}
+	}
+
+	// and those in a pipeline file
+	var pipeline primitive.Options
+	if Pipeline != "" {
+		p, err := primitive.LoadPipeline(Pipeline)
+		if err == nil {
+			pipeline, err = p.Options()
+		}
+		if err != nil {
+			ok = validationMessages(err, Pipeline+": ", false)
+		}
+	}
+	Plot := &Encoding.Plot
+	if _, err := fmt.Sscanf(Bed, "%fx%f", &Plot.Width, &Plot.Height); err != nil || Plot.Width <= 0 || Plot.Height <= 0 {
+		ok = errorMessage("ERROR: bed size must be WxH, like 297x210")
+	}
+	if Plot.Units != "mm" && Plot.Units != "in" {
+		ok = errorMessage("ERROR: units must be mm or in")
+	}
+	if Encoding.Millimeters <= 0 {
+		ok = errorMessage("ERROR: mm must be > 0")
+	}
+	var found bool
+	Encoding.Grouping, found = layerGroupings[Layers]
+	if !found {
+		ok = errorMessage("ERROR: layers must be shape, stage or type")
+	}
+	if _, found := primitive.EncoderFor(Format); !found {
+		ok = errorMessage("ERROR: format must be one of " + strings.Join(primitive.Formats(), ", "))
+	}
+	for _, output := range Outputs {
+		if err := primitive.ValidateOutput(output); err != nil {
+			ok = validationMessages(err, "", true)
+		}
+	}
+	if Progress != "" && Progress != "json" {
+		ok = errorMessage("ERROR: progress must be json")
+	}
+	if Nth < 1 {
+		ok = errorMessage("ERROR: nth must be > 0")
+	}
+	if Encoding.FPS < 1 {
+		ok = errorMessage("ERROR: fps must be > 0")
+	}
+	if Encoding.Hold < 0 {
+		ok = errorMessage("ERROR: hold must be >= 0")
+	}
+	if Encoding.VideoSize < 0 {
+		ok = errorMessage("ERROR: vs must be >= 0")
+	}
+	if Plot.Feed <= 0 {
+		ok = errorMessage("ERROR: feed must be > 0")
+	}
+	if Plot.HatchSpacing < 0 {
+		ok = errorMessage("ERROR: hatch must be >= 0")
+	}
+	if Plot.Levels < 1 {
+		ok = errorMessage("ERROR: levels must be > 0")
+	}
+	if Plot.Pens < 0 {
+		ok = errorMessage("ERROR: pens must be >= 0")
+	}
+	Encoding.Nth = Nth
 	if !ok {
-		fmt.Println("Usage: primitive [OPTIONS] -i input -o output -n count")
+		fmt.Fprintln(os.Stderr, "Usage: primitive [OPTIONS] -i input -o output -n count")
+		fmt.Fprintln(os.Stderr, "       primitive [OPTIONS] -i input -o output -config pipeline.yaml")
 		flag.PrintDefaults()
 		os.Exit(1)
 	}
 
-	// set log level
+	// log to stderr so stdout stays free for output and progress
+	level := slog.LevelWarn
 	if V {
-		primitive.LogLevel = 1
+		level = slog.LevelInfo
 	}
 	if VV {
-		primitive.LogLevel = 2
+		level = slog.LevelDebug
 	}
+	handler := slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: level})
+	slog.SetDefault(slog.New(handler))
 
-	// seed random number generator
-	rand.Seed(time.Now().UTC().UnixNano())
-
-	// determine worker count
-	if Workers < 1 {
-		Workers = runtime.NumCPU()
+	// machine-readable progress goes to stdout unless an output does
+	progress := os.Stdout
+	for _, output := range Outputs {
+		if output == "-" {
+			progress = os.Stderr
+		}
 	}
+	encoder := json.NewEncoder(progress)
 
 	// read input image
-	primitive.Log(1, "reading %s\n", Input)
+	slog.Info("reading", "path", Input)
 	input, err := primitive.LoadImage(Input)
 	check(err)
 
-	// scale down input image if needed
-	size := uint(InputSize)
-	if size > 0 {
-		input = resize.Thumbnail(size, size, input, resize.Bilinear)
+	// flags given next to -config take precedence
+	if Pipeline != "" {
+		flags := options
+		options = pipeline
+		flag.Visit(func(f *flag.Flag) {
+			switch f.Name {
+			case "r":
+				options.InputSize = flags.InputSize
+			case "s":
+				options.OutputSize = flags.OutputSize
+			case "j":
+				options.Workers = flags.Workers
+			case "seed":
+				options.Seed = flags.Seed
+			case "bg":
+				options.Background = flags.Background
+			case "filter":
+				options.Filter = flags.Filter
+			case "pyramid":
+				options.Pyramid = flags.Pyramid
+			case "refine":
+				options.Refine = flags.Refine
+			case "refine-every":
+				options.RefineEvery = flags.RefineEvery
+			case "tile":
+				options.Tile = flags.Tile
+			case "aa":
+				options.Antialias = flags.Antialias
+			}
+		})
+		if options.InputSize == 0 {
+			options.InputSize = InputSize
+		}
+		if options.OutputSize == 0 {
+			options.OutputSize = OutputSize
+		}
+	}
+	if Encoding.VideoSize < 1 {
+		Encoding.VideoSize = options.OutputSize
 	}
 
-	// determine background color
-	var bg primitive.Color
-	if Background == "" {
-		bg = primitive.MakeColor(primitive.AverageImageColor(input))
-	} else {
-		bg = primitive.MakeHexColor(Background)
-	}
-
-	// run algorithm
-	model := primitive.NewModel(input, bg, OutputSize, Workers)
-	primitive.Log(1, "%d: t=%.3f, score=%.6f\n", 0, 0.0, model.Score)
-	start := time.Now()
-	frame := 0
-	for j, config := range Configs {
-		primitive.Log(1, "count=%d, mode=%d, alpha=%d, repeat=%d\n",
-			config.Count, config.Mode, config.Alpha, config.Repeat)
-
-		for i := 0; i < config.Count; i++ {
-			frame++
-
-			// find optimal shape and add it to the model
-			t := time.Now()
-			n := model.Step(primitive.ShapeType(config.Mode), config.Alpha, config.Repeat)
-			nps := primitive.NumberString(float64(n) / time.Since(t).Seconds())
-			elapsed := time.Since(start).Seconds()
-			primitive.Log(1, "%d: t=%.3f, score=%.6f, n=%d, n/s=%s\n", frame, elapsed, model.Score, n, nps)
-
-			// write output image(s)
-			for _, output := range Outputs {
-				ext := strings.ToLower(filepath.Ext(output))
-				if output == "-" {
-					ext = ".svg"
-				}
-				percent := strings.Contains(output, "%")
-				saveFrames := percent && ext != ".gif"
-				saveFrames = saveFrames && frame%Nth == 0
-				last := j == len(Configs)-1 && i == config.Count-1
-				if saveFrames || last {
-					path := output
-					if percent {
-						path = fmt.Sprintf(output, frame)
-					}
-					primitive.Log(1, "writing %s\n", path)
-					switch ext {
-					default:
-						check(fmt.Errorf("unrecognized file extension: %s", ext))
-					case ".png":
-						check(primitive.SavePNG(path, model.Context.Image()))
-					case ".jpg", ".jpeg":
-						check(primitive.SaveJPG(path, model.Context.Image(), 95))
-					case ".svg":
-						check(primitive.SaveFile(path, model.SVG()))
-					case ".gif":
-						frames := model.Frames(0.001)
-						check(primitive.SaveGIFImageMagick(path, frames, 50, 250))
+	// write output image(s) as shapes are accepted
+	logged := 0 // stages logged so far
+	videos := make(map[string]*primitive.Video)
+	options.OnShape = func(e primitive.Event) {
+		if logged == 0 {
+			size := e.Model.Target.Bounds().Size()
+			slog.Info("target", "width", size.X, "height", size.Y,
+				"auto", e.Model.Meta.AutoSize, "filter", e.Model.Meta.Filter,
+				"pyramid", e.Model.Meta.Pyramid, "tile", e.Model.Meta.Tile,
+				"antialias", e.Model.Meta.Antialias)
+		}
+		// stages that added no shapes are logged along with the next one
+		for ; logged <= e.Stage; logged++ {
+			stage := options.Stages[logged]
+			slog.Info("stage", "count", stage.Count, "mode", stage.Mode,
+				"alpha", stage.Alpha, "repeat", stage.Repeat)
+		}
+
+		if Progress == "json" {
+			check(encoder.Encode(e))
+		}
+		nps := primitive.NumberString(float64(e.Evaluated) / e.Duration.Seconds())
+		slog.Info("shape", "frame", e.Frame, "t", e.Elapsed.Seconds(), "score", e.Score,
+			"n", e.Evaluated, "rejected", e.Rejected, "n/s", nps)
+
+		for _, output := range Outputs {
+			if outputFormat(output) == "y4m" {
+				video, ok := videos[output]
+				if !ok {
+					slog.Info("writing", "path", output)
+					w := os.Stdout
+					if output != "-" {
+						file, err := os.Create(output)
+						check(err)
+						w = file
 					}
+					video, err = e.Model.NewVideo(w, Encoding.VideoSize, Encoding.FPS, Nth)
+					check(err)
+					videos[output] = video
 				}
+				check(video.Update(e.Model))
+				continue
+			}
+			if strings.Contains(output, "%") && e.Frame%Nth == 0 {
+				saveOutput(e.Model, output, e.Frame)
 			}
 		}
 	}
+
+	// run algorithm, stopping early on interrupt
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	model, err := primitive.Run(ctx, input, options)
+	if err == context.Canceled {
+		slog.Warn("interrupted", "shapes", len(model.Shapes))
+	} else {
+		check(err)
+	}
+	if Input != "-" {
+		model.Meta.Input, _ = filepath.Abs(Input)
+	}
+	if model.Meta.Refined > 0 {
+		slog.Info("refined", "shapes", model.Meta.Refined, "score", model.Score)
+	}
+
+	// write final output(s)
+	for _, output := range Outputs {
+		if video, ok := videos[output]; ok {
+			check(video.Update(model)) // shows the final refinement passes
+			check(video.Close(Encoding.Hold))
+			continue
+		}
+		frame := len(model.Shapes)
+		if strings.Contains(output, "%") && frame > 0 && frame%Nth == 0 && model.Meta.Refined == 0 {
+			continue // already written by OnShape
+		}
+		saveOutput(model, output, frame)
+	}
+}
+
+// outputFormat returns the format of an output path, falling back to the
+// -format flag for stdout and paths without a known extension.
+func outputFormat(output string) string {
+	if output != "-" {
+		if format, ok := primitive.FormatForPath(output); ok {
+			return format
+		}
+	}
+	return strings.ToLower(Format)
+}
+
+func saveOutput(model *primitive.Model, output string, frame int) {
+	format := outputFormat(output)
+	path := output
+	if strings.Contains(output, "%") {
+		if format == "gif" {
+			return
+		}
+		path = fmt.Sprintf(output, frame)
+	}
+	slog.Info("writing", "path", path)
+	options := Encoding
+	options.Stages = model.Meta.StageEnds
+	if path == "-" {
+		check(primitive.Encode(os.Stdout, format, model, options))
+		return
+	}
+	file, err := os.Create(path)
+	check(err)
+	defer file.Close()
+	check(primitive.Encode(file, format, model, options))
 }
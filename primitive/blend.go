@@ -0,0 +1,238 @@
+package primitive
+
+import (
+	"fmt"
+	"image"
+	"math"
+
+	"github.com/fogleman/gg"
+)
+
+// BlendMode is how a shape's color combines with the pixels below it
+// before alpha compositing.
+type BlendMode int
+
+const (
+	BlendNormal BlendMode = iota
+	BlendMultiply
+	BlendScreen
+)
+
+var blendModeNames = []string{"normal", "multiply", "screen"}
+
+func (b BlendMode) String() string {
+	if b < 0 || int(b) >= len(blendModeNames) {
+		return fmt.Sprintf("BlendMode(%d)", int(b))
+	}
+	return blendModeNames[b]
+}
+
+// ParseBlendMode accepts a blend mode name, with "" meaning normal.
+func ParseBlendMode(s string) (BlendMode, error) {
+	if s == "" {
+		return BlendNormal, nil
+	}
+	for i, name := range blendModeNames {
+		if s == name {
+			return BlendMode(i), nil
+		}
+	}
+	return 0, invalid("blend", s, "unknown blend mode", suggest(s, blendModeNames))
+}
+
+// blend returns B(d, s) for channel values in [0, 1].
+func (b BlendMode) blend(d, s float64) float64 {
+	switch b {
+	case BlendMultiply:
+		return d * s
+	case BlendScreen:
+		return d + s - d*s
+	}
+	return s
+}
+
+// composite returns the premultiplied channel value of straight color s
+// drawn with alpha a over the premultiplied value d of a pixel of alpha da.
+// The backdrop is unpremultiplied to blend it with s, and where it is
+// transparent the source shows unblended, so the result is
+// a*(1-da)*s + a*da*B(d/da, s) + (1-a)*d.
+func (b BlendMode) composite(d, da, s, a float64) float64 {
+	v := a*(1-da)*s + (1-a)*d
+	if da > 0 {
+		v += a * da * b.blend(clamp(d/da, 0, 1), s)
+	}
+	return v
+}
+
+// computeBlendColor is computeColor for the other blend modes. The result
+// of composite is linear in s for both, so each channel is the least
+// squares fit over the covered pixels. The alpha channel doesn't depend on
+// s and is left out.
+func computeBlendColor(target, current *image.RGBA, lines []Scanline, alpha int, mode BlendMode) Color {
+	if mode == BlendNormal {
+		return computeColor(target, current, lines, alpha)
+	}
+	var num, den [3]float64
+	for _, line := range lines {
+		a := float64(alpha) / 255 * float64(line.Alpha) / 0xffff
+		i := target.PixOffset(line.X1, line.Y)
+		for x := line.X1; x <= line.X2; x++ {
+			da := float64(current.Pix[i+3]) / 255
+			for k := 0; k < 3; k++ {
+				t := float64(target.Pix[i+k]) / 255
+				d := float64(current.Pix[i+k]) / 255
+				// out = base + slope*s
+				var base, slope float64
+				if mode == BlendMultiply {
+					base, slope = d*(1-a), a*(1-da+d)
+				} else {
+					base, slope = d, a*(1-d)
+				}
+				num[k] += slope * (t - base)
+				den[k] += slope * slope
+			}
+			i += 4
+		}
+	}
+	var c [3]int
+	for k := range c {
+		s := 1.0 // multiplying by white leaves the pixels unchanged
+		if mode == BlendScreen {
+			s = 0 // as does screening with black
+		}
+		if den[k] > 0 {
+			s = num[k] / den[k]
+		}
+		c[k] = clampInt(int(s*255+0.5), 0, 255)
+	}
+	return Color{c[0], c[1], c[2], alpha}
+}
+
+// fitColor returns the color of a shape drawn with the given alpha and
+// blend mode, fitted weighting pixels by their coverage when shapes are
+// antialiased, see Model.SetAntialias.
+func fitColor(target, current *image.RGBA, lines []Scanline, alpha int, mode BlendMode, antialias bool) Color {
+	if antialias && mode == BlendNormal {
+		return computeCoverageColor(target, current, lines, alpha)
+	}
+	return computeBlendColor(target, current, lines, alpha, mode)
+}
+
+// computeAutoAlpha returns the alpha, between minAlpha and maxAlpha, that
+// together with the color brings the covered pixels closest to the target.
+// With v = a*s, every blend mode gives d + p*a + q*v for per-pixel p and q,
+// which is linear in a and the three v, so they are the least squares fit
+// of a small linear system: each v is solved for in terms of a, leaving a
+// single equation for a shared by the channels. The alpha channel, which
+// gains p*a with q = 0, adds its error to that equation, so shapes over
+// transparent parts of the target fade out instead of covering them.
+// Fitting the color for the clamped alpha gives the joint optimum when
+// nothing clamps. When the fit can't tell, as over a flat opaque area, the
+// most opaque alpha wins.
+func computeAutoAlpha(target, current *image.RGBA, lines []Scanline, minAlpha, maxAlpha int, mode BlendMode) int {
+	var qq, qp, qe, pp, pe [4]float64
+	for _, line := range lines {
+		m := float64(line.Alpha) / 0xffff
+		i := target.PixOffset(line.X1, line.Y)
+		for x := line.X1; x <= line.X2; x++ {
+			da := float64(current.Pix[i+3]) / 255
+			for k := 0; k < 3; k++ {
+				t := float64(target.Pix[i+k]) / 255
+				d := float64(current.Pix[i+k]) / 255
+				var p, q float64
+				switch mode {
+				case BlendMultiply:
+					p, q = -m*d, m*(1-da+d)
+				case BlendScreen:
+					p, q = 0, m*(1-d)
+				default:
+					p, q = -m*d, m
+				}
+				e := t - d
+				qq[k] += q * q
+				qp[k] += q * p
+				qe[k] += q * e
+				pp[k] += p * p
+				pe[k] += p * e
+			}
+			p := m * (1 - da)
+			pp[3] += p * p
+			pe[3] += p * (float64(target.Pix[i+3])/255 - da)
+			i += 4
+		}
+	}
+	alpha := maxAlpha
+	num, den := pe[3], pp[3]
+	for k := 0; k < 3; k++ {
+		if qq[k] > 0 {
+			num += pe[k] - qp[k]*qe[k]/qq[k]
+			den += pp[k] - qp[k]*qp[k]/qq[k]
+		}
+	}
+	if den > 1e-9 {
+		a := num / den
+		alpha = clampInt(int(math.Round(clamp(a, 0, 1)*255)), minAlpha, maxAlpha)
+	}
+	return alpha
+}
+
+// drawBlendLines is drawLines for the other blend modes.
+func drawBlendLines(im *image.RGBA, c Color, lines []Scanline, mode BlendMode) {
+	if mode == BlendNormal {
+		drawLines(im, c, lines)
+		return
+	}
+	s := [3]float64{float64(c.R) / 255, float64(c.G) / 255, float64(c.B) / 255}
+	for _, line := range lines {
+		a := float64(c.A) / 255 * float64(line.Alpha) / 0xffff
+		i := im.PixOffset(line.X1, line.Y)
+		for x := line.X1; x <= line.X2; x++ {
+			da := float64(im.Pix[i+3]) / 255
+			for k := 0; k < 3; k++ {
+				d := float64(im.Pix[i+k]) / 255
+				v := mode.composite(d, da, s[k], a)
+				im.Pix[i+k] = uint8(clamp(v, 0, 1)*255 + 0.5)
+			}
+			im.Pix[i+3] = uint8(clamp(da*(1-a)+a, 0, 1)*255 + 0.5)
+			i += 4
+		}
+	}
+}
+
+// drawShape draws shape i of the model on a context set up like
+// newContext with the given scale. gg only composites normally, so other
+// blend modes draw the shape on a separate layer and blend it in by hand.
+func (model *Model) drawShape(dc *gg.Context, i int, scale float64) {
+	c := model.Colors[i]
+	mode := model.Blends[i]
+	if mode == BlendNormal {
+		dc.SetRGBA255(c.R, c.G, c.B, c.A)
+		model.Shapes[i].Draw(dc, scale)
+		return
+	}
+	layer := gg.NewContext(dc.Width(), dc.Height())
+	layer.Scale(scale, scale)
+	layer.Translate(0.5, 0.5)
+	layer.SetRGBA255(c.R, c.G, c.B, c.A)
+	model.Shapes[i].Draw(layer, scale)
+	src := layer.Image().(*image.RGBA)
+	dst, ok := dc.Image().(*image.RGBA)
+	if !ok {
+		return
+	}
+	for p := 0; p+3 < len(src.Pix); p += 4 {
+		sa := src.Pix[p+3]
+		if sa == 0 {
+			continue
+		}
+		a := float64(sa) / 255
+		da := float64(dst.Pix[p+3]) / 255
+		for k := 0; k < 3; k++ {
+			s := float64(src.Pix[p+k]) / 255 / a // unpremultiply
+			d := float64(dst.Pix[p+k]) / 255
+			v := mode.composite(d, da, clamp(s, 0, 1), a)
+			dst.Pix[p+k] = uint8(clamp(v, 0, 1)*255 + 0.5)
+		}
+		dst.Pix[p+3] = uint8(clamp(da*(1-a)+a, 0, 1)*255 + 0.5)
+	}
+}
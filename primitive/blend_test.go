@@ -0,0 +1,110 @@
+package primitive
+
+import (
+	"image"
+	"math/rand"
+	"testing"
+)
+
+// randomPremultiplied returns an image of random premultiplied pixels of
+// every alpha, from transparent to opaque.
+func randomPremultiplied(rnd *rand.Rand, w, h int) *image.RGBA {
+	im := image.NewRGBA(image.Rect(0, 0, w, h))
+	for i := 0; i < len(im.Pix); i += 4 {
+		a := rnd.Intn(256)
+		for k := 0; k < 3; k++ {
+			im.Pix[i+k] = uint8(rnd.Intn(a + 1))
+		}
+		im.Pix[i+3] = uint8(a)
+	}
+	return im
+}
+
+func rectLines(x1, y1, x2, y2 int) []Scanline {
+	var lines []Scanline
+	for y := y1; y < y2; y++ {
+		lines = append(lines, Scanline{y, x1, x2 - 1, 0xffff})
+	}
+	return lines
+}
+
+func absInt(x int) int {
+	if x < 0 {
+		return -x
+	}
+	return x
+}
+
+func colorNear(a, b Color, d int) bool {
+	return absInt(a.R-b.R) <= d && absInt(a.G-b.G) <= d && absInt(a.B-b.B) <= d && absInt(a.A-b.A) <= d
+}
+
+// TestBlendRoundTrip draws a color over a partly transparent image in each
+// blend mode and checks that fitting the color to the result gives it back.
+func TestBlendRoundTrip(t *testing.T) {
+	rnd := rand.New(rand.NewSource(1))
+	w, h := 32, 32
+	current := randomPremultiplied(rnd, w, h)
+	lines := rectLines(0, 0, w, h)
+	want := Color{200, 90, 30, 160}
+	for _, mode := range []BlendMode{BlendNormal, BlendMultiply, BlendScreen} {
+		target := image.NewRGBA(current.Bounds())
+		copy(target.Pix, current.Pix)
+		drawBlendLines(target, want, lines, mode)
+		if got := fitColor(target, current, lines, want.A, mode, false); !colorNear(got, want, 2) {
+			t.Errorf("%v: fitted %v, want %v", mode, got, want)
+		}
+	}
+}
+
+// TestBlendTransparentBackdrop checks that every blend mode draws like
+// normal over transparent pixels, where there is nothing to blend with.
+func TestBlendTransparentBackdrop(t *testing.T) {
+	lines := rectLines(0, 0, 1, 1)
+	c := Color{200, 90, 30, 128}
+	want := image.NewRGBA(image.Rect(0, 0, 1, 1))
+	drawLines(want, c, lines)
+	for _, mode := range []BlendMode{BlendMultiply, BlendScreen} {
+		im := image.NewRGBA(image.Rect(0, 0, 1, 1))
+		drawBlendLines(im, c, lines, mode)
+		for k := range im.Pix {
+			if absInt(int(im.Pix[k])-int(want.Pix[k])) > 1 {
+				t.Errorf("%v: got %v, want %v", mode, im.Pix, want.Pix)
+				break
+			}
+		}
+	}
+}
+
+// TestAutoAlphaTransparentTarget fits shapes to a target that is half a
+// translucent red and half transparent, starting from a transparent canvas
+// as with -bg none.
+func TestAutoAlphaTransparentTarget(t *testing.T) {
+	w, h := 32, 16
+	target := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w/2; x++ {
+			i := target.PixOffset(x, y)
+			target.Pix[i+0] = 96
+			target.Pix[i+3] = 96
+		}
+	}
+	current := image.NewRGBA(target.Bounds())
+	tests := []struct {
+		name  string
+		lines []Scanline
+		want  Color
+	}{
+		{"translucent", rectLines(0, 0, w/2, h), Color{255, 0, 0, 96}},
+		{"transparent", rectLines(w/2, 0, w, h), Color{0, 0, 0, 1}},
+	}
+	for _, mode := range []BlendMode{BlendNormal, BlendMultiply, BlendScreen} {
+		for _, test := range tests {
+			alpha := computeAutoAlpha(target, current, test.lines, 1, 255, mode)
+			got := fitColor(target, current, test.lines, alpha, mode, false)
+			if !colorNear(got, test.want, 2) {
+				t.Errorf("%v %s: got %v, want %v", mode, test.name, got, test.want)
+			}
+		}
+	}
+}
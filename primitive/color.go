@@ -15,28 +15,67 @@ func MakeColor(c color.Color) Color {
 	return Color{int(r / 257), int(g / 257), int(b / 257), int(a / 257)}
 }
 
+// MakeHexColor is ParseHexColor for values known to be valid. Malformed
+// input gives opaque black.
 func MakeHexColor(x string) Color {
-	x = strings.Trim(x, "#")
-	var r, g, b, a int
-	a = 255
-	switch len(x) {
-	case 3:
-		fmt.Sscanf(x, "%1x%1x%1x", &r, &g, &b)
-		r = (r << 4) | r
-		g = (g << 4) | g
-		b = (b << 4) | b
-	case 4:
-		fmt.Sscanf(x, "%1x%1x%1x%1x", &r, &g, &b, &a)
-		r = (r << 4) | r
-		g = (g << 4) | g
-		b = (b << 4) | b
-		a = (a << 4) | a
-	case 6:
-		fmt.Sscanf(x, "%02x%02x%02x", &r, &g, &b)
-	case 8:
-		fmt.Sscanf(x, "%02x%02x%02x%02x", &r, &g, &b, &a)
+	c, err := ParseHexColor(x)
+	if err != nil {
+		return Color{0, 0, 0, 255}
 	}
-	return Color{r, g, b, a}
+	return c
+}
+
+// ParseBackground parses a background option: "" leaves it to Run, which
+// uses the average target color, "none" starts from a transparent canvas
+// and anything else must be a hex color.
+func ParseBackground(s string) (*Color, error) {
+	switch strings.ToLower(s) {
+	case "":
+		return nil, nil
+	case "none", "transparent":
+		return &Color{}, nil
+	}
+	c, err := ParseHexColor(s)
+	if err != nil {
+		e := err.(*ValidationError)
+		e.Field = "background"
+		e.Hint += `, or "none" for a transparent canvas`
+		return nil, e
+	}
+	return &c, nil
+}
+
+// ParseHexColor parses a color written as 3, 4, 6 or 8 hex digits, with an
+// optional leading #. The 4 and 8 digit forms include alpha.
+func ParseHexColor(x string) (Color, error) {
+	s := strings.TrimPrefix(x, "#")
+	digits := make([]int, len(s))
+	for i, r := range s {
+		switch {
+		case r >= '0' && r <= '9':
+			digits[i] = int(r - '0')
+		case r >= 'a' && r <= 'f':
+			digits[i] = int(r-'a') + 10
+		case r >= 'A' && r <= 'F':
+			digits[i] = int(r-'A') + 10
+		default:
+			return Color{}, invalid("color", x, fmt.Sprintf("%q is not a hex digit", r), "use hex digits like ff8800")
+		}
+	}
+	channels := []int{0, 0, 0, 255}
+	switch len(s) {
+	case 3, 4:
+		for i, d := range digits {
+			channels[i] = d<<4 | d
+		}
+	case 6, 8:
+		for i := 0; i < len(digits); i += 2 {
+			channels[i/2] = digits[i]<<4 | digits[i+1]
+		}
+	default:
+		return Color{}, invalid("color", x, "must have 3, 4, 6 or 8 hex digits", "like fff, ffffff or ffffff80 with alpha")
+	}
+	return Color{channels[0], channels[1], channels[2], channels[3]}, nil
 }
 
 func (c *Color) NRGBA() color.NRGBA {
@@ -0,0 +1,70 @@
+package primitive
+
+import "testing"
+
+func TestParseHexColor(t *testing.T) {
+	tests := []struct {
+		in   string
+		want Color
+		ok   bool
+	}{
+		{"fff", Color{255, 255, 255, 255}, true},
+		{"#f80", Color{255, 136, 0, 255}, true},
+		{"f808", Color{255, 136, 0, 136}, true},
+		{"#FF8800", Color{255, 136, 0, 255}, true},
+		{"ff880080", Color{255, 136, 0, 128}, true},
+		{"", Color{}, false},
+		{"ff", Color{}, false},
+		{"fffff", Color{}, false},
+		{"ff88zz", Color{}, false},
+		{"#", Color{}, false},
+	}
+	for _, test := range tests {
+		got, err := ParseHexColor(test.in)
+		if (err == nil) != test.ok {
+			t.Errorf("ParseHexColor(%q) error = %v, want ok %v", test.in, err, test.ok)
+			continue
+		}
+		if err != nil {
+			if e, ok := err.(*ValidationError); !ok || e.Field != "color" || e.Hint == "" {
+				t.Errorf("ParseHexColor(%q) error = %#v, want a color ValidationError with a hint", test.in, err)
+			}
+			continue
+		}
+		if got != test.want {
+			t.Errorf("ParseHexColor(%q) = %v, want %v", test.in, got, test.want)
+		}
+	}
+}
+
+func TestParseBackground(t *testing.T) {
+	tests := []struct {
+		in   string
+		want *Color
+		ok   bool
+	}{
+		{"", nil, true},
+		{"none", &Color{}, true},
+		{"Transparent", &Color{}, true},
+		{"000", &Color{0, 0, 0, 255}, true},
+		{"#ffffff80", &Color{255, 255, 255, 128}, true},
+		{"nope", nil, false},
+	}
+	for _, test := range tests {
+		got, err := ParseBackground(test.in)
+		if (err == nil) != test.ok {
+			t.Errorf("ParseBackground(%q) error = %v, want ok %v", test.in, err, test.ok)
+			continue
+		}
+		if err != nil {
+			e, ok := err.(*ValidationError)
+			if !ok || e.Field != "background" {
+				t.Errorf("ParseBackground(%q) error = %#v, want a background ValidationError", test.in, err)
+			}
+			continue
+		}
+		if (got == nil) != (test.want == nil) || (got != nil && *got != *test.want) {
+			t.Errorf("ParseBackground(%q) = %v, want %v", test.in, got, test.want)
+		}
+	}
+}
@@ -5,6 +5,12 @@ import (
 	"math"
 )
 
+// computeColor returns the color that, drawn with the given alpha, brings
+// the covered pixels closest to the target. Both images are premultiplied
+// and drawLines gives d*(1-a) + s*a in each color channel, so the mean of
+// (t-d)/a + d is the least squares fit of s in premultiplied space. The
+// alpha channel becomes da*(1-a) + a whatever s is, so its error can't
+// change the fit; computeAutoAlpha is what takes it into account.
 func computeColor(target, current *image.RGBA, lines []Scanline, alpha int) Color {
 	var rsum, gsum, bsum, count int64
 	a := 0x101 * 255 / alpha
@@ -72,6 +78,37 @@ func computeColor(target, current *image.RGBA, lines []Scanline, alpha int) Colo
 	return Color{r, g, b, alpha}
 }
 
+// computeCoverageColor is computeColor for antialiased shapes, whose lines
+// have partial coverage that drawLines blends with alpha scaled by the
+// coverage w. Each channel is the least squares fit of d*(1-w) + s*w to the
+// target, weighting each pixel by its coverage. computeColor weighs every
+// pixel the same, which is what the search has always done for the shapes
+// that rasterize with partial coverage anyway.
+func computeCoverageColor(target, current *image.RGBA, lines []Scanline, alpha int) Color {
+	var num, den [3]float64
+	for _, line := range lines {
+		w := float64(alpha) / 255 * float64(line.Alpha) / 0xffff
+		i := target.PixOffset(line.X1, line.Y)
+		for x := line.X1; x <= line.X2; x++ {
+			for k := 0; k < 3; k++ {
+				t := float64(target.Pix[i+k])
+				d := float64(current.Pix[i+k])
+				num[k] += w * (t - d*(1-w))
+				den[k] += w * w
+			}
+			i += 4
+		}
+	}
+	if den[0] == 0 {
+		return Color{}
+	}
+	var c [3]int
+	for k := range c {
+		c[k] = clampInt(int(num[k]/den[k]+0.5), 0, 255)
+	}
+	return Color{c[0], c[1], c[2], alpha}
+}
+
 func copyLines(dst, src *image.RGBA, lines []Scanline) {
 	for _, line := range lines {
 		a := dst.PixOffset(line.X1, line.Y)
@@ -80,6 +117,45 @@ func copyLines(dst, src *image.RGBA, lines []Scanline) {
 	}
 }
 
+// saveLines appends the pixels of im under lines to buf, one span after
+// another, and returns it.
+func saveLines(buf []uint8, im *image.RGBA, lines []Scanline) []uint8 {
+	for _, line := range lines {
+		if line.X2 < line.X1 {
+			continue
+		}
+		a := im.PixOffset(line.X1, line.Y)
+		b := a + (line.X2-line.X1+1)*4
+		buf = append(buf, im.Pix[a:b]...)
+	}
+	return buf
+}
+
+// differenceSaved is differencePartial with the pixels before the change
+// given as the spans saved by saveLines, instead of a whole image.
+func differenceSaved(target, after *image.RGBA, before []uint8, score float64, lines []Scanline) float64 {
+	size := target.Bounds().Size()
+	w, h := size.X, size.Y
+	total := uint64(math.Pow(score*255, 2) * float64(w*h*4))
+	j := 0
+	for _, line := range lines {
+		if line.X2 < line.X1 {
+			continue
+		}
+		i := target.PixOffset(line.X1, line.Y)
+		n := (line.X2 - line.X1 + 1) * 4
+		t, b, a := target.Pix[i:i+n], before[j:j+n], after.Pix[i:i+n]
+		for k := 0; k < n; k++ {
+			d1 := int(t[k]) - int(b[k])
+			d2 := int(t[k]) - int(a[k])
+			total -= uint64(d1 * d1)
+			total += uint64(d2 * d2)
+		}
+		j += n
+	}
+	return math.Sqrt(float64(total)/float64(w*h*4)) / 255
+}
+
 func drawLines(im *image.RGBA, c Color, lines []Scanline) {
 	const m = 0xffff
 	sr, sg, sb, sa := c.NRGBA().RGBA()
@@ -176,6 +252,9 @@ func differenceFull(a, b *image.RGBA) float64 {
 	return math.Sqrt(float64(total)/float64(w*h*4)) / 255
 }
 
+// differencePartial returns the score of after, which differs from before,
+// of the given score, only under lines. It compares the pixels as drawn, so
+// lines with partial coverage are weighted by it through the blending.
 func differencePartial(target, before, after *image.RGBA, score float64, lines []Scanline) float64 {
 	size := target.Bounds().Size()
 	w, h := size.X, size.Y
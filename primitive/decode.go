@@ -0,0 +1,219 @@
+package primitive
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"io"
+
+	_ "golang.org/x/image/bmp"
+	_ "golang.org/x/image/tiff"
+	_ "golang.org/x/image/webp"
+)
+
+// imageSignatures names formats by their leading bytes, including some that
+// cannot be decoded, so that errors can say what the input was.
+var imageSignatures = []struct {
+	format string
+	offset int
+	magic  string
+}{
+	{"png", 0, "\x89PNG\r\n\x1a\n"},
+	{"jpeg", 0, "\xff\xd8"},
+	{"gif", 0, "GIF8"},
+	{"bmp", 0, "BM"},
+	{"tiff", 0, "II*\x00"},
+	{"tiff", 0, "MM\x00*"},
+	{"webp", 8, "WEBP"},
+	{"heic", 4, "ftypheic"},
+	{"heic", 4, "ftypheix"},
+	{"heic", 4, "ftypmif1"},
+	{"avif", 4, "ftypavif"},
+	{"psd", 0, "8BPS"},
+	{"pdf", 0, "%PDF"},
+	{"svg", 0, "<svg"},
+	{"svg", 0, "<?xml"},
+}
+
+// sniffFormat returns the format of an encoded image, or "" if unknown.
+func sniffFormat(data []byte) string {
+	for _, s := range imageSignatures {
+		if len(data) >= s.offset+len(s.magic) && string(data[s.offset:s.offset+len(s.magic)]) == s.magic {
+			return s.format
+		}
+	}
+	return ""
+}
+
+// DecodeImage decodes a PNG, JPEG, GIF, BMP, TIFF or WebP image. JPEGs are
+// turned upright according to their EXIF orientation, and CMYK and 16 bit
+// images are converted to 8 bit RGB. Errors name the detected format.
+func DecodeImage(r io.Reader) (image.Image, error) {
+	return DecodeImageLimit(r, 0)
+}
+
+// DecodeImageLimit is like DecodeImage but refuses images of more than
+// maxPixels pixels, from the dimensions in their header, before allocating
+// them. A limit of 0 or less means none.
+func DecodeImageLimit(r io.Reader, maxPixels int) (image.Image, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	format := sniffFormat(data)
+	decodeError := func(err error) error {
+		switch {
+		case format == "":
+			return errors.New("unrecognized image format")
+		case errors.Is(err, image.ErrFormat):
+			return fmt.Errorf("%s images are not supported; convert it to png or jpeg", format)
+		}
+		return fmt.Errorf("decoding %s image: %v", format, err)
+	}
+	if maxPixels > 0 {
+		config, _, err := image.DecodeConfig(bytes.NewReader(data))
+		if err != nil {
+			return nil, decodeError(err)
+		}
+		if int64(config.Width)*int64(config.Height) > int64(maxPixels) {
+			return nil, fmt.Errorf("%s image is %dx%d, more than %d pixels", format, config.Width, config.Height, maxPixels)
+		}
+	}
+	im, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, decodeError(err)
+	}
+	im = normalizeImage(im)
+	if format == "jpeg" {
+		im = orientImage(im, exifOrientation(data))
+	}
+	return im, nil
+}
+
+// normalizeImage converts CMYK and 16 bit images to 8 bit NRGBA, rounding
+// each channel, so that resizing and imageToRGBA see plain sRGB. CMYK uses
+// the standard library's conversion; ICC profiles are ignored.
+func normalizeImage(im image.Image) image.Image {
+	switch im.(type) {
+	case *image.CMYK, *image.RGBA64, *image.NRGBA64, *image.Gray16:
+	default:
+		return im
+	}
+	b := im.Bounds()
+	dst := image.NewNRGBA(b)
+	round := func(v uint16) uint8 { return uint8((uint32(v) + 128) / 257) }
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		i := dst.PixOffset(b.Min.X, y)
+		for x := b.Min.X; x < b.Max.X; x++ {
+			c := color.NRGBA64Model.Convert(im.At(x, y)).(color.NRGBA64)
+			dst.Pix[i+0] = round(c.R)
+			dst.Pix[i+1] = round(c.G)
+			dst.Pix[i+2] = round(c.B)
+			dst.Pix[i+3] = round(c.A)
+			i += 4
+		}
+	}
+	return dst
+}
+
+// exifOrientation returns the orientation tag (1 to 8) from a JPEG's EXIF
+// segment, or 1 when there is none.
+func exifOrientation(data []byte) int {
+	if len(data) < 4 || data[0] != 0xff || data[1] != 0xd8 {
+		return 1
+	}
+	for i := 2; i+4 <= len(data) && data[i] == 0xff; {
+		marker := data[i+1]
+		size := int(binary.BigEndian.Uint16(data[i+2:]))
+		if marker == 0xda || size < 2 || i+2+size > len(data) {
+			break // image data follows, or a broken segment
+		}
+		segment := data[i+4 : i+2+size]
+		if marker == 0xe1 && bytes.HasPrefix(segment, []byte("Exif\x00\x00")) {
+			return tiffOrientation(segment[6:])
+		}
+		i += 2 + size
+	}
+	return 1
+}
+
+// tiffOrientation reads the orientation tag from the first IFD of the TIFF
+// structure inside an EXIF segment.
+func tiffOrientation(tiff []byte) int {
+	if len(tiff) < 8 {
+		return 1
+	}
+	var order binary.ByteOrder
+	switch string(tiff[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return 1
+	}
+	ifd := int(order.Uint32(tiff[4:]))
+	if ifd < 8 || ifd+2 > len(tiff) {
+		return 1
+	}
+	count := int(order.Uint16(tiff[ifd:]))
+	for k := 0; k < count; k++ {
+		entry := ifd + 2 + k*12
+		if entry+12 > len(tiff) {
+			break
+		}
+		tag := order.Uint16(tiff[entry:])
+		kind := order.Uint16(tiff[entry+2:])
+		if tag == 0x0112 && kind == 3 { // orientation, SHORT
+			if o := int(order.Uint16(tiff[entry+8:])); o >= 1 && o <= 8 {
+				return o
+			}
+			return 1
+		}
+	}
+	return 1
+}
+
+// orientImage applies an EXIF orientation, returning an upright image.
+// Orientations 5 to 8 swap width and height.
+func orientImage(im image.Image, orientation int) image.Image {
+	if orientation < 2 || orientation > 8 {
+		return im
+	}
+	b := im.Bounds()
+	src := image.NewNRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+	draw.Draw(src, src.Rect, im, b.Min, draw.Src)
+	w, h := b.Dx(), b.Dy()
+	dw, dh := w, h
+	if orientation >= 5 {
+		dw, dh = h, w
+	}
+	dst := image.NewNRGBA(image.Rect(0, 0, dw, dh))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			var dx, dy int
+			switch orientation {
+			case 2: // mirrored
+				dx, dy = w-1-x, y
+			case 3: // upside down
+				dx, dy = w-1-x, h-1-y
+			case 4: // upside down and mirrored
+				dx, dy = x, h-1-y
+			case 5: // transposed
+				dx, dy = y, x
+			case 6: // rotated 90 degrees clockwise
+				dx, dy = h-1-y, x
+			case 7: // transversed
+				dx, dy = h-1-y, w-1-x
+			case 8: // rotated 90 degrees counterclockwise
+				dx, dy = y, w-1-x
+			}
+			copy(dst.Pix[dst.PixOffset(dx, dy):][:4], src.Pix[src.PixOffset(x, y):][:4])
+		}
+	}
+	return dst
+}
@@ -0,0 +1,110 @@
+package primitive
+
+import (
+	"encoding/binary"
+	"image"
+	"testing"
+)
+
+// exifJPEG returns the start of a JPEG with an APP1 EXIF segment holding
+// an orientation tag in the given byte order ("II" or "MM"), followed by
+// the start of the image data.
+func exifJPEG(order string, orientation int) []byte {
+	var bo binary.ByteOrder = binary.LittleEndian
+	if order == "MM" {
+		bo = binary.BigEndian
+	}
+	tiff := make([]byte, 8+2+12+4)
+	copy(tiff, order)
+	bo.PutUint16(tiff[2:], 42)
+	bo.PutUint32(tiff[4:], 8) // first IFD
+	bo.PutUint16(tiff[8:], 1) // one entry
+	bo.PutUint16(tiff[10:], 0x0112)
+	bo.PutUint16(tiff[12:], 3) // SHORT
+	bo.PutUint32(tiff[14:], 1)
+	bo.PutUint16(tiff[18:], uint16(orientation))
+	segment := append([]byte("Exif\x00\x00"), tiff...)
+	data := []byte{0xff, 0xd8, 0xff, 0xe1, 0, 0}
+	binary.BigEndian.PutUint16(data[4:], uint16(2+len(segment)))
+	data = append(data, segment...)
+	return append(data, 0xff, 0xda, 0, 2)
+}
+
+func TestEXIFOrientation(t *testing.T) {
+	tests := []struct {
+		name string
+		data []byte
+		want int
+	}{
+		{"little endian", exifJPEG("II", 6), 6},
+		{"big endian", exifJPEG("MM", 8), 8},
+		{"every value", exifJPEG("MM", 5), 5},
+		{"out of range", exifJPEG("II", 9), 1},
+		{"truncated segment", exifJPEG("II", 6)[:20], 1},
+		{"no exif", []byte{0xff, 0xd8, 0xff, 0xda, 0, 2}, 1},
+		{"not a jpeg", []byte("\x89PNG\r\n\x1a\n"), 1},
+		{"empty", nil, 1},
+	}
+	for _, test := range tests {
+		if got := exifOrientation(test.data); got != test.want {
+			t.Errorf("%s: got orientation %d, want %d", test.name, got, test.want)
+		}
+	}
+	// an IFD cut short inside a well formed segment
+	tiff := exifJPEG("MM", 3)[12:]
+	for _, n := range []int{4, 9, 14, 21} {
+		if got := tiffOrientation(tiff[:n]); got != 1 {
+			t.Errorf("IFD truncated to %d bytes: got orientation %d, want 1", n, got)
+		}
+	}
+}
+
+// TestOrientImage turns a 2x3 image with a different value in every pixel
+// and checks each orientation against the layout it must give, read row by
+// row. The source is
+//
+//	1 2
+//	3 4
+//	5 6
+func TestOrientImage(t *testing.T) {
+	src := image.NewNRGBA(image.Rect(0, 0, 2, 3))
+	for i := 0; i < 6; i++ {
+		src.Pix[i*4] = uint8(i + 1)
+		src.Pix[i*4+3] = 255
+	}
+	tests := []struct {
+		orientation int
+		w, h        int
+		want        []uint8
+	}{
+		{1, 2, 3, []uint8{1, 2, 3, 4, 5, 6}},
+		{2, 2, 3, []uint8{2, 1, 4, 3, 6, 5}}, // mirrored
+		{3, 2, 3, []uint8{6, 5, 4, 3, 2, 1}}, // upside down
+		{4, 2, 3, []uint8{5, 6, 3, 4, 1, 2}}, // upside down and mirrored
+		{5, 3, 2, []uint8{1, 3, 5, 2, 4, 6}}, // transposed
+		{6, 3, 2, []uint8{5, 3, 1, 6, 4, 2}}, // rotated 90 degrees clockwise
+		{7, 3, 2, []uint8{6, 4, 2, 5, 3, 1}}, // transversed
+		{8, 3, 2, []uint8{2, 4, 6, 1, 3, 5}}, // rotated 90 degrees counterclockwise
+	}
+	for _, test := range tests {
+		im := orientImage(src, test.orientation)
+		b := im.Bounds()
+		if b.Dx() != test.w || b.Dy() != test.h {
+			t.Errorf("orientation %d: got %dx%d, want %dx%d", test.orientation, b.Dx(), b.Dy(), test.w, test.h)
+			continue
+		}
+		var got []uint8
+		for y := b.Min.Y; y < b.Max.Y; y++ {
+			for x := b.Min.X; x < b.Max.X; x++ {
+				r, _, _, _ := im.At(x, y).RGBA()
+				got = append(got, uint8(r>>8))
+			}
+		}
+		for i := range got {
+			if got[i] != test.want[i] {
+				t.Errorf("orientation %d: got %v, want %v", test.orientation, got, test.want)
+				break
+			}
+		}
+	}
+}
@@ -0,0 +1,170 @@
+package primitive
+
+import (
+	"fmt"
+	"math"
+	"strings"
+)
+
+type dxfWriter struct {
+	lines  []string
+	handle int
+}
+
+func (d *dxfWriter) group(code int, value interface{}) {
+	var s string
+	switch v := value.(type) {
+	case float64:
+		s = fmt.Sprintf("%.6f", v)
+	default:
+		s = fmt.Sprint(v)
+	}
+	d.lines = append(d.lines, fmt.Sprint(code), s)
+}
+
+func (d *dxfWriter) entity(kind, layer, subclass string) {
+	d.handle++
+	d.group(0, kind)
+	d.group(5, fmt.Sprintf("%X", d.handle))
+	d.group(100, "AcDbEntity")
+	d.group(8, layer)
+	d.group(100, subclass)
+}
+
+func dxfLayerName(c Color) string {
+	return fmt.Sprintf("COLOR_%02X%02X%02X", c.R, c.G, c.B)
+}
+
+// DXF returns the shapes as exact CAD entities (AutoCAD 2000 DXF) in
+// millimeters, scaled so the longest side of the image is size mm.
+// Polygonal shapes become closed LWPOLYLINEs, ellipses and circles become
+// ELLIPSEs and beziers become degree 2 SPLINEs along their centerline. Each
+// entity is placed on a layer named after its color quantized to the given
+// number of levels per channel.
+func (model *Model) DXF(size float64, levels int) string {
+	w := float64(model.Target.Bounds().Dx())
+	h := float64(model.Target.Bounds().Dy())
+	f := size / math.Max(w, h)
+	point := func(x, y float64) (float64, float64) {
+		return (x + 0.5) * f, (h - y - 0.5) * f
+	}
+
+	var layers []Color
+	seen := make(map[Color]bool)
+	for _, c := range model.Colors {
+		c = quantizeColor(c, levels)
+		if !seen[c] {
+			seen[c] = true
+			layers = append(layers, c)
+		}
+	}
+
+	d := &dxfWriter{handle: 0x100}
+	d.group(0, "SECTION")
+	d.group(2, "HEADER")
+	d.group(9, "$ACADVER")
+	d.group(1, "AC1015")
+	d.group(9, "$INSUNITS")
+	d.group(70, 4)
+	d.group(9, "$EXTMIN")
+	d.group(10, 0.0)
+	d.group(20, 0.0)
+	d.group(9, "$EXTMAX")
+	d.group(10, w*f)
+	d.group(20, h*f)
+	d.group(0, "ENDSEC")
+
+	d.group(0, "SECTION")
+	d.group(2, "TABLES")
+	d.group(0, "TABLE")
+	d.group(2, "LAYER")
+	d.group(5, "2")
+	d.group(100, "AcDbSymbolTable")
+	d.group(70, len(layers))
+	for _, c := range layers {
+		d.handle++
+		d.group(0, "LAYER")
+		d.group(5, fmt.Sprintf("%X", d.handle))
+		d.group(100, "AcDbSymbolTableRecord")
+		d.group(100, "AcDbLayerTableRecord")
+		d.group(2, dxfLayerName(c))
+		d.group(70, 0)
+		d.group(62, 7)
+		d.group(420, c.R<<16|c.G<<8|c.B)
+		d.group(6, "CONTINUOUS")
+	}
+	d.group(0, "ENDTAB")
+	d.group(0, "ENDSEC")
+
+	d.group(0, "SECTION")
+	d.group(2, "ENTITIES")
+	for i, shape := range model.Shapes {
+		layer := dxfLayerName(quantizeColor(model.Colors[i], levels))
+		switch s := shape.(type) {
+		case *Ellipse:
+			dxfEllipse(d, layer, f, point, float64(s.X), float64(s.Y), float64(s.Rx), float64(s.Ry), 0)
+		case *RotatedEllipse:
+			dxfEllipse(d, layer, f, point, s.X, s.Y, s.Rx, s.Ry, radians(s.Angle))
+		case *Quadratic:
+			d.entity("SPLINE", layer, "AcDbSpline")
+			d.group(210, 0.0)
+			d.group(220, 0.0)
+			d.group(230, 1.0)
+			d.group(70, 8)
+			d.group(71, 2)
+			d.group(72, 6)
+			d.group(73, 3)
+			d.group(74, 0)
+			for _, k := range []float64{0, 0, 0, 1, 1, 1} {
+				d.group(40, k)
+			}
+			for _, p := range [][2]float64{{s.X1, s.Y1}, {s.X2, s.Y2}, {s.X3, s.Y3}} {
+				x, y := point(p[0], p[1])
+				d.group(10, x)
+				d.group(20, y)
+				d.group(30, 0.0)
+			}
+		default:
+			points, closed := shapeOutline(shape, 1)
+			if !closed || len(points) < 3 {
+				continue
+			}
+			points = points[:len(points)-1]
+			d.entity("LWPOLYLINE", layer, "AcDbPolyline")
+			d.group(90, len(points))
+			d.group(70, 1)
+			for _, p := range points {
+				x, y := point(p.X, p.Y)
+				d.group(10, x)
+				d.group(20, y)
+			}
+		}
+	}
+	d.group(0, "ENDSEC")
+	d.group(0, "EOF")
+	return strings.Join(d.lines, "\n") + "\n"
+}
+
+func dxfEllipse(d *dxfWriter, layer string, f float64, point func(x, y float64) (float64, float64), x, y, rx, ry, angle float64) {
+	// major axis endpoint relative to the center, in image coordinates
+	mx, my := rotate(rx, 0, angle)
+	ratio := ry / rx
+	if ry > rx {
+		mx, my = rotate(0, ry, angle)
+		ratio = rx / ry
+	}
+	cx, cy := point(x, y)
+	d.entity("ELLIPSE", layer, "AcDbEllipse")
+	d.group(10, cx)
+	d.group(20, cy)
+	d.group(30, 0.0)
+	d.group(11, mx*f)
+	d.group(21, -my*f)
+	d.group(31, 0.0)
+	d.group(210, 0.0)
+	d.group(220, 0.0)
+	d.group(230, 1.0)
+	d.group(40, ratio)
+	d.group(41, 0.0)
+	d.group(42, 2*math.Pi)
+}
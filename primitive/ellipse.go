@@ -2,6 +2,7 @@ package primitive
 
 import (
 	"fmt"
+	"image"
 	"math"
 
 	"github.com/fogleman/gg"
@@ -81,6 +82,10 @@ func (c *Ellipse) Mutate() {
 }
 
 func (c *Ellipse) Rasterize() []Scanline {
+	if c.Worker.Antialias {
+		x, y := float64(c.X), float64(c.Y)
+		return fillPath(c.Worker, ellipsePath(c.Worker, x, y, float64(c.Rx), float64(c.Ry), 0))
+	}
 	w := c.Worker.W
 	h := c.Worker.H
 	lines := c.Worker.Lines[:0]
@@ -94,7 +99,6 @@ func (c *Ellipse) Rasterize() []Scanline {
 	// Pre-compute constants to avoid repeated calculations
 	rx := c.Rx
 	ry := c.Ry
-	rx2 := rx * rx
 	ry2 := ry * ry
 	aspect := float64(rx) / float64(ry)
 	
@@ -163,6 +167,12 @@ func (c *Ellipse) Rasterize() []Scanline {
 	return lines
 }
 
+func (c *Ellipse) Bounds() image.Rectangle {
+	x, y := float64(c.X), float64(c.Y)
+	rx, ry := float64(c.Rx), float64(c.Ry)
+	return boundsOf(c.Worker, x-rx, y-ry, x+rx, y+ry)
+}
+
 type RotatedEllipse struct {
 	Worker *Worker
 	X, Y   float64
@@ -243,7 +253,14 @@ func (c *RotatedEllipse) Rasterize() []Scanline {
 	   c.Y+maxRadius < 0 || c.Y-maxRadius >= float64(h) {
 		return c.Worker.Lines[:0]
 	}
-	
+	return fillPath(c.Worker, ellipsePath(c.Worker, c.X, c.Y, c.Rx, c.Ry, c.Angle))
+}
+
+// ellipsePath approximates an ellipse, rotated by angle degrees, with
+// quadratic curves.
+func ellipsePath(worker *Worker, x, y, rx, ry, angle float64) raster.Path {
+	maxRadius := math.Max(rx, ry)
+
 	// Use fewer segments for small ellipses to improve performance
 	n := 16
 	if maxRadius < 16 {
@@ -255,7 +272,7 @@ func (c *RotatedEllipse) Rasterize() []Scanline {
 	var path raster.Path
 	
 	// Pre-compute angle values to avoid repeated calculations
-	angleRad := radians(c.Angle)
+	angleRad := radians(angle)
 	sinAngle := math.Sin(angleRad)
 	cosAngle := math.Cos(angleRad)
 	
@@ -272,12 +289,12 @@ func (c *RotatedEllipse) Rasterize() []Scanline {
 		cosMid, sinMid := math.Cos(a1+(a2-a1)/2), math.Sin(a1+(a2-a1)/2)
 		
 		// Compute ellipse points
-		x0 := c.Rx * cos1
-		y0 := c.Ry * sin1
-		x1 := c.Rx * cosMid
-		y1 := c.Ry * sinMid
-		x2 := c.Rx * cos2
-		y2 := c.Ry * sin2
+		x0 := rx * cos1
+		y0 := ry * sin1
+		x1 := rx * cosMid
+		y1 := ry * sinMid
+		x2 := rx * cos2
+		y2 := ry * sin2
 		
 		// Bezier control point
 		cx := 2*x1 - x0/2 - x2/2
@@ -292,9 +309,16 @@ func (c *RotatedEllipse) Rasterize() []Scanline {
 		y2Rot := x2*sinAngle + y2*cosAngle
 		
 		if i == 0 {
-			path.Start(fixp(x0Rot+c.X, y0Rot+c.Y))
+			path.Start(worker.point(x0Rot+x, y0Rot+y))
 		}
-		path.Add2(fixp(cxRot+c.X, cyRot+c.Y), fixp(x2Rot+c.X, y2Rot+c.Y))
+		path.Add2(worker.point(cxRot+x, cyRot+y), worker.point(x2Rot+x, y2Rot+y))
 	}
-	return fillPath(c.Worker, path)
+	return path
+}
+
+func (c *RotatedEllipse) Bounds() image.Rectangle {
+	// the curves of Rasterize stay within their control points, which lie
+	// less than 8% outside the ellipse
+	r := math.Max(c.Rx, c.Ry) * 1.1
+	return boundsOf(c.Worker, c.X-r, c.Y-r, c.X+r, c.Y+r)
 }
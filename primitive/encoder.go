@@ -0,0 +1,153 @@
+package primitive
+
+import (
+	"fmt"
+	"image/png"
+	"io"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// EncoderOptions holds the settings used by the format specific encoders.
+// Encoders ignore the settings that do not apply to them.
+type EncoderOptions struct {
+	Quality     int           // jpg
+	Delay       int           // gif, in hundredths of a second
+	LastDelay   int           // gif
+	VideoSize   int           // y4m, 0 uses the model output size
+	FPS         int           // y4m
+	Hold        float64       // y4m, seconds
+	Nth         int           // y4m
+	Grouping    LayerGrouping // ora
+	Stages      []int         // ora, number of shapes at the end of each stage
+	Millimeters float64       // dxf
+	Plot        PlotOptions   // gcode, hpgl, dxf (levels)
+}
+
+func DefaultEncoderOptions() EncoderOptions {
+	return EncoderOptions{
+		Quality:     95,
+		Delay:       50,
+		LastDelay:   250,
+		FPS:         30,
+		Hold:        2,
+		Nth:         1,
+		Millimeters: 300,
+		Plot:        DefaultPlotOptions(),
+	}
+}
+
+// Encoder writes a model to w in one output format.
+type Encoder interface {
+	Encode(w io.Writer, model *Model, options EncoderOptions) error
+}
+
+type EncoderFunc func(w io.Writer, model *Model, options EncoderOptions) error
+
+func (f EncoderFunc) Encode(w io.Writer, model *Model, options EncoderOptions) error {
+	return f(w, model, options)
+}
+
+var (
+	encoders   = make(map[string]Encoder)
+	extensions = make(map[string]string)
+)
+
+// RegisterEncoder makes an encoder available under a format name and the
+// given file extensions (including the dot).
+func RegisterEncoder(format string, encoder Encoder, exts ...string) {
+	encoders[format] = encoder
+	for _, ext := range exts {
+		extensions[strings.ToLower(ext)] = format
+	}
+}
+
+func EncoderFor(format string) (Encoder, bool) {
+	encoder, ok := encoders[strings.ToLower(format)]
+	return encoder, ok
+}
+
+// FormatForPath returns the format registered for the path's extension.
+func FormatForPath(path string) (string, bool) {
+	format, ok := extensions[strings.ToLower(filepath.Ext(path))]
+	return format, ok
+}
+
+// Formats returns the registered format names in sorted order.
+func Formats() []string {
+	var result []string
+	for format := range encoders {
+		result = append(result, format)
+	}
+	sort.Strings(result)
+	return result
+}
+
+func encodeString(s func(model *Model, options EncoderOptions) string) Encoder {
+	return EncoderFunc(func(w io.Writer, model *Model, options EncoderOptions) error {
+		_, err := io.WriteString(w, s(model, options))
+		return err
+	})
+}
+
+func init() {
+	RegisterEncoder("png", EncoderFunc(func(w io.Writer, model *Model, options EncoderOptions) error {
+		return png.Encode(w, model.Context.Image())
+	}), ".png")
+	RegisterEncoder("jpg", EncoderFunc(func(w io.Writer, model *Model, options EncoderOptions) error {
+		return WriteJPG(w, model.Context.Image(), options.Quality)
+	}), ".jpg", ".jpeg")
+	RegisterEncoder("gif", EncoderFunc(func(w io.Writer, model *Model, options EncoderOptions) error {
+		frames := model.Frames(0.001)
+		if _, err := exec.LookPath("convert"); err != nil {
+			return WriteGIF(w, frames, options.Delay, options.LastDelay)
+		}
+		return WriteGIFImageMagick(w, frames, options.Delay, options.LastDelay)
+	}), ".gif")
+	RegisterEncoder("svg", encodeString(func(model *Model, options EncoderOptions) string {
+		return model.SVG()
+	}), ".svg")
+	RegisterEncoder("html", encodeString(func(model *Model, options EncoderOptions) string {
+		return model.HTML()
+	}), ".html")
+	RegisterEncoder("y4m", EncoderFunc(func(w io.Writer, model *Model, options EncoderOptions) error {
+		size := options.VideoSize
+		if size <= 0 {
+			size = maxInt(model.Sw, model.Sh)
+		}
+		video, err := model.NewVideo(w, size, options.FPS, options.Nth)
+		if err != nil {
+			return err
+		}
+		if err := video.Update(model); err != nil {
+			return err
+		}
+		return video.Close(options.Hold)
+	}), ".y4m")
+	RegisterEncoder("ora", EncoderFunc(func(w io.Writer, model *Model, options EncoderOptions) error {
+		return model.WriteORA(w, options.Grouping, options.Stages)
+	}), ".ora")
+	RegisterEncoder("json", EncoderFunc(func(w io.Writer, model *Model, options EncoderOptions) error {
+		return model.WriteScene(w)
+	}), ".json")
+	RegisterEncoder("dxf", encodeString(func(model *Model, options EncoderOptions) string {
+		return model.DXF(options.Millimeters, options.Plot.Levels)
+	}), ".dxf")
+	RegisterEncoder("gcode", encodeString(func(model *Model, options EncoderOptions) string {
+		return model.GCode(options.Plot)
+	}), ".gcode", ".nc")
+	RegisterEncoder("hpgl", encodeString(func(model *Model, options EncoderOptions) string {
+		return model.HPGL(options.Plot)
+	}), ".hpgl", ".plt")
+}
+
+// Encode writes the model in the given format.
+func Encode(w io.Writer, format string, model *Model, options EncoderOptions) error {
+	encoder, ok := EncoderFor(format)
+	if !ok {
+		return fmt.Errorf("unrecognized output format: %s", format)
+	}
+	return encoder.Encode(w, model, options)
+}
@@ -0,0 +1,72 @@
+package primitive
+
+import (
+	"bytes"
+	"io"
+	"reflect"
+	"testing"
+)
+
+func TestFormatForPath(t *testing.T) {
+	tests := []struct {
+		path   string
+		format string
+		ok     bool
+	}{
+		{"out.png", "png", true},
+		{"OUT.PNG", "png", true},
+		{"out.jpeg", "jpg", true},
+		{"dir.v2/out.svg", "svg", true},
+		{"out.nc", "gcode", true},
+		{"out.plt", "hpgl", true},
+		{"out.json", "json", true},
+		{"out.y4m", "y4m", true},
+		{"out", "", false},
+		{"out.txt", "", false},
+	}
+	for _, test := range tests {
+		format, ok := FormatForPath(test.path)
+		if format != test.format || ok != test.ok {
+			t.Errorf("FormatForPath(%q) = %q, %v, want %q, %v", test.path, format, ok, test.format, test.ok)
+		}
+	}
+}
+
+// TestEncode checks that Encode looks formats up in the registry, case
+// insensitively, and that every registered format writes something.
+func TestEncode(t *testing.T) {
+	want := []string{"dxf", "gcode", "gif", "hpgl", "html", "jpg", "json", "ora", "png", "svg", "y4m"}
+	if got := Formats(); !reflect.DeepEqual(got, want) {
+		t.Fatalf("Formats() = %v, want %v", got, want)
+	}
+	model := testRun(t, testTarget(t, 32), testRuns[0].options, 1)
+	options := DefaultEncoderOptions()
+	for _, format := range append(want, "PNG") {
+		var buf bytes.Buffer
+		if err := Encode(&buf, format, model, options); err != nil {
+			t.Errorf("%s: %v", format, err)
+		} else if buf.Len() == 0 {
+			t.Errorf("%s: no output", format)
+		}
+	}
+	if err := Encode(io.Discard, "bmp", model, options); err == nil || err.Error() != "unrecognized output format: bmp" {
+		t.Errorf("bmp: error %v", err)
+	}
+
+	// a registered encoder is found by name and extension
+	var called bool
+	RegisterEncoder("test", EncoderFunc(func(w io.Writer, model *Model, options EncoderOptions) error {
+		called = true
+		return nil
+	}), ".tst")
+	defer func() {
+		delete(encoders, "test")
+		delete(extensions, ".tst")
+	}()
+	if format, ok := FormatForPath("out.TST"); !ok || format != "test" {
+		t.Errorf("FormatForPath(out.TST) = %q, %v", format, ok)
+	}
+	if err := Encode(io.Discard, "Test", model, options); err != nil || !called {
+		t.Errorf("test encoder: error %v, called %v", err, called)
+	}
+}
@@ -0,0 +1,222 @@
+package primitive
+
+import (
+	"strconv"
+	"strings"
+)
+
+func compactFloat(x float64) string {
+	return strconv.FormatFloat(x, 'f', -1, 32)
+}
+
+// ShapesJSON returns the shape list as compact JSON. Each shape is an array
+// of its type (as in -m), its RGBA color and its parameters.
+func (model *Model) ShapesJSON() string {
+	var items []string
+	for i, shape := range model.Shapes {
+		t, params := ShapeData(shape)
+		c := model.Colors[i]
+		fields := []string{
+			strconv.Itoa(int(t)),
+			strconv.Itoa(c.R), strconv.Itoa(c.G), strconv.Itoa(c.B), strconv.Itoa(c.A)}
+		for _, p := range params {
+			fields = append(fields, compactFloat(p))
+		}
+		items = append(items, "["+strings.Join(fields, ",")+"]")
+	}
+	return "[" + strings.Join(items, ",\n") + "]"
+}
+
+// HTML returns a standalone page with a canvas player that draws the shapes
+// progressively, using the same transform and drawing rules as Shape.Draw.
+func (model *Model) HTML() string {
+	bg := model.Background
+	fields := []string{
+		"{\"w\":" + strconv.Itoa(model.Sw),
+		"\"h\":" + strconv.Itoa(model.Sh),
+		"\"scale\":" + compactFloat(model.Scale),
+		"\"bg\":[" + strconv.Itoa(bg.R) + "," + strconv.Itoa(bg.G) + "," + strconv.Itoa(bg.B) + "," + strconv.Itoa(bg.A) + "]",
+	}
+	// blend modes map onto canvas composite operations of the same name
+	var blends []string
+	blended := false
+	for _, blend := range model.Blends {
+		name := "source-over"
+		if blend != BlendNormal {
+			name = blend.String()
+			blended = true
+		}
+		blends = append(blends, "\""+name+"\"")
+	}
+	if blended {
+		fields = append(fields, "\"blends\":["+strings.Join(blends, ",")+"]")
+	}
+	fields = append(fields, "\"shapes\":"+model.ShapesJSON()+"}")
+	data := strings.Join(fields, ",")
+	return strings.Replace(htmlPlayer, "{{DATA}}", data, 1)
+}
+
+const htmlPlayer = `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>primitive</title>
+<style>
+html, body { margin: 0; height: 100%; background: #222; color: #ddd; font: 14px sans-serif; }
+body { display: flex; flex-direction: column; }
+#view { flex: 1; display: flex; align-items: center; justify-content: center; overflow: hidden; }
+#controls { display: flex; align-items: center; gap: 8px; padding: 8px; }
+#slider { flex: 1; }
+#count { min-width: 6em; text-align: right; font-variant-numeric: tabular-nums; }
+</style>
+</head>
+<body>
+<div id="view"><canvas id="canvas"></canvas></div>
+<div id="controls">
+<button id="play">Pause</button>
+<input id="slider" type="range" min="0" value="0">
+<span id="count"></span>
+</div>
+<script>
+var data = {{DATA}};
+(function() {
+	var canvas = document.getElementById("canvas");
+	var view = document.getElementById("view");
+	var button = document.getElementById("play");
+	var slider = document.getElementById("slider");
+	var count = document.getElementById("count");
+	var ctx = canvas.getContext("2d");
+	var shapes = data.shapes;
+	var drawn = 0, target = 0, playing = true, last = null;
+	var rate = Math.max(shapes.length / 10, 10);
+
+	slider.max = shapes.length;
+
+	// mirrors Model.newContext: scale(model.Scale) then translate(0.5, 0.5)
+	function reset() {
+		var fit = Math.min(view.clientWidth / data.w, view.clientHeight / data.h);
+		var ratio = window.devicePixelRatio || 1;
+		canvas.style.width = Math.floor(data.w * fit) + "px";
+		canvas.style.height = Math.floor(data.h * fit) + "px";
+		canvas.width = Math.max(1, Math.floor(data.w * fit * ratio));
+		canvas.height = Math.max(1, Math.floor(data.h * fit * ratio));
+		var s = canvas.width / data.w;
+		ctx.setTransform(s, 0, 0, s, 0, 0);
+		ctx.globalCompositeOperation = "source-over";
+		ctx.fillStyle = "rgba(" + data.bg.slice(0, 3).join(",") + "," + data.bg[3] / 255 + ")";
+		ctx.fillRect(0, 0, data.w, data.h);
+		ctx.scale(data.scale, data.scale);
+		ctx.translate(0.5, 0.5);
+		ctx.lineCap = "round";
+		ctx.lineJoin = "round";
+		drawn = 0;
+	}
+
+	// mirrors the Draw method of each Shape
+	function draw(shape, index) {
+		var t = shape[0], p = shape.slice(5);
+		ctx.globalCompositeOperation = data.blends ? data.blends[index] : "source-over";
+		var style = "rgba(" + shape[1] + "," + shape[2] + "," + shape[3] + "," + shape[4] / 255 + ")";
+		ctx.beginPath();
+		switch (t) {
+		case 1: // triangle
+			ctx.moveTo(p[0], p[1]);
+			ctx.lineTo(p[2], p[3]);
+			ctx.lineTo(p[4], p[5]);
+			ctx.closePath();
+			break;
+		case 2: // rectangle
+			ctx.rect(p[0], p[1], p[2] - p[0] + 1, p[3] - p[1] + 1);
+			break;
+		case 3: // ellipse
+		case 4: // circle
+			ctx.ellipse(p[0], p[1], p[2], p[3], 0, 0, 2 * Math.PI);
+			break;
+		case 5: // rotated rectangle
+			ctx.save();
+			ctx.translate(p[0], p[1]);
+			ctx.rotate(p[4] * Math.PI / 180);
+			ctx.rect(-p[2] / 2, -p[3] / 2, p[2], p[3]);
+			ctx.restore();
+			break;
+		case 6: // quadratic bezier stroke
+			// gg strokes Width*scale device pixels; canvas scales lineWidth itself
+			ctx.moveTo(p[0], p[1]);
+			ctx.quadraticCurveTo(p[2], p[3], p[4], p[5]);
+			ctx.lineWidth = p[6];
+			ctx.strokeStyle = style;
+			ctx.stroke();
+			return;
+		case 7: // rotated ellipse
+			ctx.ellipse(p[0], p[1], p[2], p[3], p[4] * Math.PI / 180, 0, 2 * Math.PI);
+			break;
+		case 8: // polygon
+			ctx.moveTo(p[0], p[1]);
+			for (var i = 2; i < p.length; i += 2) {
+				ctx.lineTo(p[i], p[i + 1]);
+			}
+			ctx.closePath();
+			break;
+		}
+		ctx.fillStyle = style;
+		ctx.fill();
+	}
+
+	function render() {
+		var n = Math.floor(target);
+		if (n < drawn) {
+			reset();
+		}
+		while (drawn < n) {
+			draw(shapes[drawn], drawn);
+			drawn++;
+		}
+		slider.value = n;
+		count.textContent = n + " / " + shapes.length;
+	}
+
+	function tick(now) {
+		if (playing) {
+			if (last !== null) {
+				target = Math.min(target + rate * (now - last) / 1000, shapes.length);
+			}
+			last = now;
+			render();
+			if (target >= shapes.length) {
+				setPlaying(false);
+			}
+		}
+		window.requestAnimationFrame(tick);
+	}
+
+	function setPlaying(value) {
+		playing = value;
+		last = null;
+		button.textContent = playing ? "Pause" : "Play";
+	}
+
+	button.addEventListener("click", function() {
+		if (!playing && target >= shapes.length) {
+			target = 0;
+		}
+		setPlaying(!playing);
+	});
+	slider.addEventListener("input", function() {
+		setPlaying(false);
+		target = Number(slider.value);
+		render();
+	});
+	window.addEventListener("resize", function() {
+		reset();
+		render();
+	});
+
+	reset();
+	render();
+	window.requestAnimationFrame(tick);
+})();
+</script>
+</body>
+</html>
+`
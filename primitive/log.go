@@ -1,12 +1,40 @@
 package primitive
 
-import "fmt"
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strings"
+)
 
+// The package logs diagnostics through slog's default logger at debug
+// level, and runs that deliver less than was asked for at warning level, so
+// applications control the destination, format and level with
+// slog.SetDefault. Progress is not logged; use Options.OnShape instead.
+
+func debug(msg string, args ...interface{}) {
+	slog.Debug(msg, args...)
+}
+
+func warn(msg string, args ...interface{}) {
+	slog.Warn(msg, args...)
+}
+
+// LogLevel is the most verbose level that Log passes on.
+//
+// Deprecated: the package logs through slog; set its level with
+// slog.SetDefault or slog.SetLogLoggerLevel instead.
 var LogLevel int
 
+// Log formats a message and passes it to slog's default logger if level is
+// at most LogLevel: level 1 logs at info level and each level above that
+// 4 lower, so level 2 is debug.
+//
+// Deprecated: use log/slog.
 func Log(level int, format string, a ...interface{}) {
 	if LogLevel >= level {
-		fmt.Printf(format, a...)
+		msg := strings.TrimSpace(fmt.Sprintf(format, a...))
+		slog.Log(context.Background(), slog.LevelInfo-slog.Level(4*(level-1)), msg)
 	}
 }
 
@@ -15,9 +43,9 @@ func v(format string, a ...interface{}) {
 }
 
 func vv(format string, a ...interface{}) {
-	Log(2, "  "+format, a...)
+	Log(2, format, a...)
 }
 
 func vvv(format string, a ...interface{}) {
-	Log(3, "    "+format, a...)
+	Log(3, format, a...)
 }
@@ -1,6 +1,7 @@
 package primitive
 
 import (
+	"context"
 	"fmt"
 	"image"
 	"strings"
@@ -9,34 +10,33 @@ import (
 )
 
 type Model struct {
-	Sw, Sh     int
-	Scale      float64
-	Background Color
-	Target     *image.RGBA
-	Current    *image.RGBA
-	Context    *gg.Context
-	Score      float64
-	Shapes     []Shape
-	Colors     []Color
-	Scores     []float64
-	Workers    []*Worker
+	Sw, Sh      int
+	Scale       float64
+	Background  Color
+	Target      *image.RGBA
+	Current     *image.RGBA
+	Context     *gg.Context
+	Score       float64
+	Shapes      []Shape
+	Colors      []Color
+	Scores      []float64
+	Blends      []BlendMode
+	Workers     []*Worker
+	Meta        Metadata
+	limits      limits
+	layerLimits []limits      // the limits each shape was added under
+	pyramid     []*image.RGBA // coarse targets, see SetPyramid
+	level       int
+	tiles       *tiler        // see SetTiles
+	spans       []uint8       // scratch for add, see saveLines
+	antialias   bool          // see SetAntialias
+	slots       chan struct{} // see SetSlots
 }
 
 func NewModel(target image.Image, background Color, size, numWorkers int) *Model {
 	w := target.Bounds().Size().X
 	h := target.Bounds().Size().Y
-	aspect := float64(w) / float64(h)
-	var sw, sh int
-	var scale float64
-	if aspect >= 1 {
-		sw = size
-		sh = int(float64(size) / aspect)
-		scale = float64(size) / float64(w)
-	} else {
-		sw = int(float64(size) * aspect)
-		sh = size
-		scale = float64(size) / float64(h)
-	}
+	sw, sh, scale := outputSize(w, h, size)
 
 	model := &Model{}
 	model.Sw = sw
@@ -54,6 +54,59 @@ func NewModel(target image.Image, background Color, size, numWorkers int) *Model
 	return model
 }
 
+// SetAntialias sets whether every shape is rasterized with fractional
+// coverage at its edges, as it is drawn for output, so that the score is
+// that of the image written, and fits colors weighting pixels by their
+// coverage. Otherwise shapes that have a cheaper hard edged rasterization
+// use it. Shapes already added keep their pixels.
+func (model *Model) SetAntialias(on bool) {
+	model.antialias = on
+	for _, worker := range model.Workers {
+		worker.Antialias = on
+		for _, level := range worker.levels {
+			level.Antialias = on
+		}
+	}
+}
+
+// SetSlots limits the workers searching at once to the capacity of slots,
+// which may be shared with other models so that they divide the cores
+// between them as they go. A worker holds a slot for each task it runs.
+// The shapes found don't depend on how the tasks are spread over the
+// workers, so this only affects speed. nil, the default, doesn't limit
+// them.
+func (model *Model) SetSlots(slots chan struct{}) {
+	model.slots = slots
+}
+
+// acquire waits for a slot, see SetSlots.
+func (model *Model) acquire() {
+	if model.slots != nil {
+		model.slots <- struct{}{}
+	}
+}
+
+// release frees a slot taken by acquire.
+func (model *Model) release() {
+	if model.slots != nil {
+		<-model.slots
+	}
+}
+
+func outputSize(w, h, size int) (sw, sh int, scale float64) {
+	aspect := float64(w) / float64(h)
+	if aspect >= 1 {
+		sw = size
+		sh = int(float64(size) / aspect)
+		scale = float64(size) / float64(w)
+	} else {
+		sw = int(float64(size) * aspect)
+		sh = size
+		scale = float64(size) / float64(h)
+	}
+	return
+}
+
 func (model *Model) newContext() *gg.Context {
 	dc := gg.NewContext(model.Sw, model.Sh)
 	dc.Scale(model.Scale, model.Scale)
@@ -68,11 +121,8 @@ func (model *Model) Frames(scoreDelta float64) []image.Image {
 	dc := model.newContext()
 	result = append(result, imageToRGBA(dc.Image()))
 	previous := 10.0
-	for i, shape := range model.Shapes {
-		c := model.Colors[i]
-		dc.SetRGBA255(c.R, c.G, c.B, c.A)
-		shape.Draw(dc, model.Scale)
-		dc.Fill()
+	for i := range model.Shapes {
+		model.drawShape(dc, i, model.Scale)
 		score := model.Scores[i]
 		delta := previous - score
 		if delta >= scoreDelta {
@@ -87,12 +137,20 @@ func (model *Model) SVG() string {
 	bg := model.Background
 	var lines []string
 	lines = append(lines, fmt.Sprintf("<svg xmlns=\"http://www.w3.org/2000/svg\" version=\"1.1\" width=\"%d\" height=\"%d\">", model.Sw, model.Sh))
-	lines = append(lines, fmt.Sprintf("<rect x=\"0\" y=\"0\" width=\"%d\" height=\"%d\" fill=\"#%02x%02x%02x\" />", model.Sw, model.Sh, bg.R, bg.G, bg.B))
+	switch {
+	case bg.A == 255:
+		lines = append(lines, fmt.Sprintf("<rect x=\"0\" y=\"0\" width=\"%d\" height=\"%d\" fill=\"#%02x%02x%02x\" />", model.Sw, model.Sh, bg.R, bg.G, bg.B))
+	case bg.A > 0:
+		lines = append(lines, fmt.Sprintf("<rect x=\"0\" y=\"0\" width=\"%d\" height=\"%d\" fill=\"#%02x%02x%02x\" fill-opacity=\"%f\" />", model.Sw, model.Sh, bg.R, bg.G, bg.B, float64(bg.A)/255))
+	}
 	lines = append(lines, fmt.Sprintf("<g transform=\"scale(%f) translate(0.5 0.5)\">", model.Scale))
 	for i, shape := range model.Shapes {
 		c := model.Colors[i]
 		attrs := "fill=\"#%02x%02x%02x\" fill-opacity=\"%f\""
 		attrs = fmt.Sprintf(attrs, c.R, c.G, c.B, float64(c.A)/255)
+		if blend := model.Blends[i]; blend != BlendNormal {
+			attrs += fmt.Sprintf(" style=\"mix-blend-mode:%s\"", blend)
+		}
 		lines = append(lines, shape.SVG(attrs))
 	}
 	lines = append(lines, "</g>")
@@ -101,35 +159,102 @@ func (model *Model) SVG() string {
 }
 
 func (model *Model) Add(shape Shape, alpha int) {
-	before := copyRGBA(model.Current)
 	lines := shape.Rasterize()
-	color := computeColor(model.Target, model.Current, lines, alpha)
-	drawLines(model.Current, color, lines)
-	score := differencePartial(model.Target, before, model.Current, model.Score, lines)
+	color := model.limits.color(model.Target, model.Current, lines, alpha, model.antialias)
+	model.add(shape, color, model.limits.Blend, lines)
+}
+
+// AddColor adds a shape with a known color, as when loading a scene.
+func (model *Model) AddColor(shape Shape, color Color, blend BlendMode) {
+	model.add(shape, color, blend, shape.Rasterize())
+}
+
+func (model *Model) add(shape Shape, color Color, blend BlendMode, lines []Scanline) {
+	// only the pixels under the shape change, so only those are kept for
+	// scoring, in a buffer reused from shape to shape
+	model.spans = saveLines(model.spans[:0], model.Current, lines)
+	drawBlendLines(model.Current, color, lines, blend)
+	score := differenceSaved(model.Target, model.Current, model.spans, model.Score, lines)
 
 	model.Score = score
 	model.Shapes = append(model.Shapes, shape)
 	model.Colors = append(model.Colors, color)
 	model.Scores = append(model.Scores, score)
+	model.Blends = append(model.Blends, blend)
+	model.layerLimits = append(model.layerLimits, model.limits)
 
-	model.Context.SetRGBA255(color.R, color.G, color.B, color.A)
-	shape.Draw(model.Context, model.Scale)
+	model.drawShape(model.Context, len(model.Shapes)-1, model.Scale)
 }
 
 func (model *Model) Step(shapeType ShapeType, alpha, repeat int) int {
-	state := model.runWorkers(shapeType, alpha, 1000, 100, 16)
+	stage := Stage{Mode: shapeType, Alpha: alpha, Repeat: repeat}
+	n, _, _ := model.step(context.Background(), stage, DefaultSearch, limits{}, nil)
+	return n
+}
+
+// step finds and adds the next shape of the stage (plus up to Repeat extra
+// shapes) within the given limits and returns the number of candidates
+// evaluated and how many of them were rejected by their bounds. added is
+// called after each shape is added with the same two counts for finding
+// it. If ctx is cancelled the workers stop early and no shape is added.
+func (model *Model) step(ctx context.Context, stage Stage, search Search, l limits, added func(n, rejected int)) (int, int, error) {
+	model.setLimits(l)
+	t := stage.Mode
+	if len(stage.Modes) > 0 {
+		t = ShapeTypeAny // picks from l.Types
+	}
+	var state *State
+	counter, rejected := 0, 0
+	if model.level > 0 {
+		state, counter, rejected = model.searchPyramid(ctx, t, stage.Alpha, search)
+	} else {
+		state = model.runWorkers(ctx, model.Workers, model.Current, model.Score,
+			t, stage.Alpha, search.Candidates, search.Age, search.Restarts)
+		for _, worker := range model.Workers {
+			counter += worker.Counter
+			rejected += worker.Rejected
+		}
+	}
+	if err := ctx.Err(); err != nil {
+		return 0, 0, err
+	}
 	// state = HillClimb(state, 1000).(*State)
 	model.Add(state.Shape, state.Alpha)
+	if model.level > 0 {
+		model.updateLevel(state.Shape)
+	}
 
-	for i := 0; i < repeat; i++ {
+	if added != nil {
+		added(counter, rejected)
+	}
+
+	// the extra shapes are climbed by the first worker, whose random source
+	// doesn't depend on which worker found the first shape, and which stops
+	// climbing when ctx is cancelled
+	if adopted := adoptState(state, model.Workers[0]); adopted != nil {
+		state = adopted
+	}
+	state.Worker.ctx = ctx
+	defer func(worker *Worker) { worker.ctx = context.Background() }(state.Worker)
+	for i := 0; i < stage.Repeat; i++ {
+		if ctx.Err() != nil {
+			break
+		}
 		state.Worker.Init(model.Current, model.Score)
 		a := state.Energy()
-		state = HillClimb(state, 100).(*State)
+		model.acquire()
+		state = HillClimb(state, search.Age).(*State)
+		model.release()
 		b := state.Energy()
+		counter += state.Worker.Counter
+		rejected += state.Worker.Rejected
 		if a == b {
 			break
 		}
 		model.Add(state.Shape, state.Alpha)
+		if added != nil {
+			added(state.Worker.Counter, state.Worker.Rejected)
+		}
 	}
 
 	// for _, w := range model.Workers[1:] {
@@ -137,38 +262,5 @@ func (model *Model) Step(shapeType ShapeType, alpha, repeat int) int {
 	// }
 	// SavePNG("heatmap.png", model.Workers[0].Heatmap.Image(0.5))
 
-	counter := 0
-	for _, worker := range model.Workers {
-		counter += worker.Counter
-	}
-	return counter
-}
-
-func (model *Model) runWorkers(t ShapeType, a, n, age, m int) *State {
-	wn := len(model.Workers)
-	ch := make(chan *State, wn)
-	wm := m / wn
-	if m%wn != 0 {
-		wm++
-	}
-	for i := 0; i < wn; i++ {
-		worker := model.Workers[i]
-		worker.Init(model.Current, model.Score)
-		go model.runWorker(worker, t, a, n, age, wm, ch)
-	}
-	var bestEnergy float64
-	var bestState *State
-	for i := 0; i < wn; i++ {
-		state := <-ch
-		energy := state.Energy()
-		if i == 0 || energy < bestEnergy {
-			bestEnergy = energy
-			bestState = state
-		}
-	}
-	return bestState
-}
-
-func (model *Model) runWorker(worker *Worker, t ShapeType, a, n, age, m int, ch chan *State) {
-	ch <- worker.BestHillClimbState(t, a, n, age, m)
+	return counter, rejected, nil
 }
@@ -0,0 +1,36 @@
+package primitive
+
+import (
+	"fmt"
+	"testing"
+)
+
+// BenchmarkModelAdd measures adding a shape that has already been found:
+// rasterizing it, fitting its color, drawing it and scoring the change.
+// Triangles from NewRandomTriangle have the same size in pixels at every
+// working size, so only costs that grow with the image show up as a
+// difference between sizes.
+func BenchmarkModelAdd(b *testing.B) {
+	im, err := LoadImage("../examples/monalisa.png")
+	if err != nil {
+		b.Fatal(err)
+	}
+	for _, size := range []int{256, 512} {
+		b.Run(fmt.Sprintf("%dx%d", size, size), func(b *testing.B) {
+			target := resample(im, size, size, FilterBilinear)
+			model := NewModel(target, MakeColor(AverageImageColor(target)), size, 1)
+			worker := model.Workers[0]
+			worker.Rnd.Seed(1)
+			worker.Init(model.Current, model.Score)
+			shapes := make([]Shape, 1000)
+			for i := range shapes {
+				shapes[i] = NewRandomTriangle(worker)
+			}
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				model.Add(shapes[i%len(shapes)], 128)
+			}
+		})
+	}
+}
@@ -12,14 +12,46 @@ type Annealable interface {
 	Copy() Annealable
 }
 
+// boundedAnnealable is implemented by states that can tell cheaply that
+// their energy can't be below a limit, see State.EnergyBelow.
+type boundedAnnealable interface {
+	EnergyBelow(limit float64) float64
+}
+
+// energyBelow returns the energy of state, or any value not below limit
+// if it isn't below it.
+func energyBelow(state Annealable, limit float64) float64 {
+	if b, ok := state.(boundedAnnealable); ok {
+		return b.EnergyBelow(limit)
+	}
+	return state.Energy()
+}
+
+// cancelableAnnealable is implemented by states whose search can be
+// cancelled, see State.canceled.
+type cancelableAnnealable interface {
+	canceled() bool
+}
+
+// cancelCheck is the number of moves HillClimb makes between checks for
+// cancellation.
+const cancelCheck = 64
+
+// HillClimb makes random moves, keeping those that lower the energy, until
+// maxAge moves in a row have not. If the state's search is cancelled it
+// stops early and returns the best state found so far.
 func HillClimb(state Annealable, maxAge int) Annealable {
 	state = state.Copy()
 	bestState := state.Copy()
 	bestEnergy := state.Energy()
+	cancelable, _ := state.(cancelableAnnealable)
 	step := 0
 	for age := 0; age < maxAge; age++ {
+		if cancelable != nil && step%cancelCheck == cancelCheck-1 && cancelable.canceled() {
+			break
+		}
 		undo := state.DoMove()
-		energy := state.Energy()
+		energy := energyBelow(state, bestEnergy)
 		if energy >= bestEnergy {
 			state.UndoMove(undo)
 		} else {
@@ -0,0 +1,222 @@
+package primitive
+
+import (
+	"archive/zip"
+	"encoding/xml"
+	"fmt"
+	"image"
+	"image/png"
+	"io"
+	"os"
+	"time"
+
+	"github.com/fogleman/gg"
+	"github.com/nfnt/resize"
+)
+
+// LayerGrouping selects which shapes share a layer in OpenRaster output.
+type LayerGrouping int
+
+const (
+	LayerPerShape LayerGrouping = iota
+	LayerPerStage
+	LayerPerType
+)
+
+type oraLayer struct {
+	Name      string
+	Shapes    []int
+	Opacity   float64
+	Composite string
+}
+
+type oraStackLayer struct {
+	Name       string  `xml:"name,attr"`
+	Src        string  `xml:"src,attr"`
+	X          int     `xml:"x,attr"`
+	Y          int     `xml:"y,attr"`
+	Opacity    float64 `xml:"opacity,attr"`
+	Visibility string  `xml:"visibility,attr"`
+	Composite  string  `xml:"composite-op,attr"`
+}
+
+type oraImage struct {
+	XMLName xml.Name        `xml:"image"`
+	Version string          `xml:"version,attr"`
+	W       int             `xml:"w,attr"`
+	H       int             `xml:"h,attr"`
+	Layers  []oraStackLayer `xml:"stack>layer"`
+}
+
+// oraLayers groups the shapes into layers, bottom first. stages holds the
+// number of shapes in the model at the end of each stage. Per-shape layers
+// draw the shape opaque and carry its alpha as the layer opacity so it can
+// be edited, and blend modes become the layer's composite op; grouped
+// layers keep each shape's alpha in the pixels and draw every shape
+// normally. Grouping by type stacks the groups in order of first
+// appearance.
+func (model *Model) oraLayers(grouping LayerGrouping, stages []int) []oraLayer {
+	var layers []oraLayer
+	switch grouping {
+	case LayerPerShape:
+		for i, shape := range model.Shapes {
+			t, _ := ShapeData(shape)
+			name := fmt.Sprintf("%d %s", i+1, t)
+			layers = append(layers, oraLayer{
+				name, []int{i}, float64(model.Colors[i].A) / 255, "svg:" + model.Blends[i].String()})
+		}
+	case LayerPerStage:
+		start := 0
+		for j, end := range append(stages, len(model.Shapes)) {
+			end = minInt(end, len(model.Shapes))
+			if end <= start {
+				continue
+			}
+			var shapes []int
+			for i := start; i < end; i++ {
+				shapes = append(shapes, i)
+			}
+			name := fmt.Sprintf("stage %d", j+1)
+			layers = append(layers, oraLayer{name, shapes, 1, ""})
+			start = end
+		}
+	case LayerPerType:
+		index := make(map[ShapeType]int)
+		for i, shape := range model.Shapes {
+			t, _ := ShapeData(shape)
+			j, ok := index[t]
+			if !ok {
+				j = len(layers)
+				index[t] = j
+				layers = append(layers, oraLayer{t.String(), nil, 1, ""})
+			}
+			layers[j].Shapes = append(layers[j].Shapes, i)
+		}
+	}
+	return layers
+}
+
+func (model *Model) renderLayer(layer oraLayer) *image.RGBA {
+	dc := gg.NewContext(model.Sw, model.Sh)
+	dc.Scale(model.Scale, model.Scale)
+	dc.Translate(0.5, 0.5)
+	for _, i := range layer.Shapes {
+		c := model.Colors[i]
+		if len(layer.Shapes) == 1 && layer.Opacity < 1 {
+			c.A = 255
+		}
+		dc.SetRGBA255(c.R, c.G, c.B, c.A)
+		model.Shapes[i].Draw(dc, model.Scale)
+	}
+	return imageToRGBA(dc.Image())
+}
+
+// opaqueBounds returns the smallest rectangle containing every pixel with
+// non-zero alpha.
+func opaqueBounds(im *image.RGBA) image.Rectangle {
+	var r image.Rectangle
+	b := im.Bounds()
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		i := im.PixOffset(b.Min.X, y)
+		for x := b.Min.X; x < b.Max.X; x++ {
+			if im.Pix[i+3] != 0 {
+				r = r.Union(image.Rect(x, y, x+1, y+1))
+			}
+			i += 4
+		}
+	}
+	return r
+}
+
+// WriteORA writes the model as an OpenRaster document: a background layer,
+// unless the background is transparent, plus one layer per shape, stage or
+// shape type, each cropped to its content, along with the merged image and
+// a thumbnail.
+func (model *Model) WriteORA(w io.Writer, grouping LayerGrouping, stages []int) error {
+	z := zip.NewWriter(w)
+	add := func(name string, method uint16, write func(io.Writer) error) error {
+		header := &zip.FileHeader{Name: name, Method: method, Modified: time.Now()}
+		f, err := z.CreateHeader(header)
+		if err != nil {
+			return err
+		}
+		return write(f)
+	}
+	addPNG := func(name string, im image.Image) error {
+		return add(name, zip.Store, func(f io.Writer) error {
+			return png.Encode(f, im)
+		})
+	}
+
+	// the mimetype must be the first, uncompressed entry
+	err := add("mimetype", zip.Store, func(f io.Writer) error {
+		_, err := io.WriteString(f, "image/openraster")
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	doc := oraImage{Version: "0.0.5", W: model.Sw, H: model.Sh}
+	// a transparent background gets no layer
+	if model.Background.A > 0 {
+		background := uniformRGBA(image.Rect(0, 0, model.Sw, model.Sh), model.Background.NRGBA())
+		if err := addPNG("data/background.png", background); err != nil {
+			return err
+		}
+		doc.Layers = append(doc.Layers, oraStackLayer{
+			"background", "data/background.png", 0, 0, 1, "visible", "svg:src-over"})
+	}
+
+	layers := model.oraLayers(grouping, stages)
+	for i, layer := range layers {
+		im := model.renderLayer(layer)
+		bounds := opaqueBounds(im)
+		if bounds.Empty() {
+			continue
+		}
+		src := fmt.Sprintf("data/%04d.png", i+1)
+		if err := addPNG(src, im.SubImage(bounds)); err != nil {
+			return err
+		}
+		composite := layer.Composite
+		if composite == "" || composite == "svg:normal" {
+			composite = "svg:src-over"
+		}
+		doc.Layers = append(doc.Layers, oraStackLayer{
+			layer.Name, src, bounds.Min.X, bounds.Min.Y, layer.Opacity, "visible", composite})
+	}
+
+	// stack.xml lists layers top first
+	for i, j := 0, len(doc.Layers)-1; i < j; i, j = i+1, j-1 {
+		doc.Layers[i], doc.Layers[j] = doc.Layers[j], doc.Layers[i]
+	}
+	err = add("stack.xml", zip.Deflate, func(f io.Writer) error {
+		if _, err := io.WriteString(f, xml.Header); err != nil {
+			return err
+		}
+		return xml.NewEncoder(f).Encode(doc)
+	})
+	if err != nil {
+		return err
+	}
+
+	merged := model.Context.Image()
+	if err := addPNG("mergedimage.png", merged); err != nil {
+		return err
+	}
+	thumbnail := resize.Thumbnail(256, 256, merged, resize.Bilinear)
+	if err := addPNG("Thumbnails/thumbnail.png", thumbnail); err != nil {
+		return err
+	}
+	return z.Close()
+}
+
+func SaveORA(path string, model *Model, grouping LayerGrouping, stages []int) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	return model.WriteORA(file, grouping, stages)
+}
@@ -0,0 +1,265 @@
+package primitive
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Pipeline describes a run in a YAML or JSON file, with every stage spelled
+// out instead of depending on the order of command line flags:
+//
+//	input_size: auto
+//	filter: lanczos
+//	pyramid: 2
+//	refine: 1
+//	output_size: 1024
+//	stages:
+//	  - count: 50
+//	    shape: rect
+//	    alpha: 255
+//	    min_size: 16
+//	  - count: 200
+//	    shapes: [triangle, ellipse]
+//	    search: {candidates: 500, age: 50}
+//	    mask: face.png
+//	    blend: multiply
+//	  - count: 100
+//	    shape: ellipse
+//	    alpha: auto
+//	    min_alpha: 32
+//
+// Zero values select the same defaults as Options.
+type Pipeline struct {
+	InputSize   WorkingSize     `json:"input_size" yaml:"input_size"`
+	Filter      string          `json:"filter" yaml:"filter"`
+	Pyramid     int             `json:"pyramid" yaml:"pyramid"`
+	Refine      int             `json:"refine" yaml:"refine"`
+	Tile        int             `json:"tile" yaml:"tile"`
+	Antialias   bool            `json:"antialias" yaml:"antialias"`
+	RefineEvery int             `json:"refine_every" yaml:"refine_every"`
+	OutputSize  int             `json:"output_size" yaml:"output_size"`
+	Background  string          `json:"background" yaml:"background"`
+	Seed        int64           `json:"seed" yaml:"seed"`
+	Workers     int             `json:"workers" yaml:"workers"`
+	Search      Search          `json:"search" yaml:"search"`
+	Stages      []PipelineStage `json:"stages" yaml:"stages"`
+
+	// Dir is where relative mask paths are looked up.
+	Dir string `json:"-" yaml:"-"`
+}
+
+// WorkingSize is an input size that can also be written as "auto", for
+// InputSizeAuto.
+type WorkingSize int
+
+func (s *WorkingSize) parse(text string) error {
+	if text == "auto" {
+		*s = InputSizeAuto
+		return nil
+	}
+	n, err := strconv.Atoi(text)
+	if err != nil {
+		return fmt.Errorf("input_size must be a number or \"auto\", got %q", text)
+	}
+	*s = WorkingSize(n)
+	return nil
+}
+
+func (s *WorkingSize) UnmarshalJSON(data []byte) error {
+	return s.parse(strings.Trim(string(data), `"`))
+}
+
+func (s *WorkingSize) UnmarshalYAML(node *yaml.Node) error {
+	return s.parse(node.Value)
+}
+
+// AlphaValue is a stage alpha that can also be written as "auto", for
+// AlphaAuto.
+type AlphaValue int
+
+func (a *AlphaValue) parse(text string) error {
+	if text == "auto" {
+		*a = AlphaAuto
+		return nil
+	}
+	n, err := strconv.Atoi(text)
+	if err != nil {
+		return fmt.Errorf("alpha must be a number or \"auto\", got %q", text)
+	}
+	*a = AlphaValue(n)
+	return nil
+}
+
+func (a AlphaValue) MarshalJSON() ([]byte, error) {
+	if a == AlphaAuto {
+		return []byte(`"auto"`), nil
+	}
+	return []byte(strconv.Itoa(int(a))), nil
+}
+
+func (a *AlphaValue) UnmarshalJSON(data []byte) error {
+	return a.parse(strings.Trim(string(data), `"`))
+}
+
+func (a *AlphaValue) UnmarshalYAML(node *yaml.Node) error {
+	return a.parse(node.Value)
+}
+
+// PipelineStage is one stage of a pipeline. Shape names are those of -m
+// (or their numbers) and a missing alpha means 128, as on the command line.
+type PipelineStage struct {
+	Count    int         `json:"count" yaml:"count"`
+	Shape    string      `json:"shape" yaml:"shape"`
+	Shapes   []string    `json:"shapes" yaml:"shapes"`
+	Alpha    *AlphaValue `json:"alpha" yaml:"alpha"`
+	Repeat   int         `json:"repeat" yaml:"repeat"`
+	Search   Search      `json:"search" yaml:"search"`
+	MinSize  int         `json:"min_size" yaml:"min_size"`
+	MaxSize  int         `json:"max_size" yaml:"max_size"`
+	MinAlpha int         `json:"min_alpha" yaml:"min_alpha"`
+	MaxAlpha int         `json:"max_alpha" yaml:"max_alpha"`
+	Mask     string      `json:"mask" yaml:"mask"`
+	Blend    string      `json:"blend" yaml:"blend"`
+}
+
+// LoadPipeline reads a pipeline file, as JSON if its extension is .json and
+// as YAML otherwise. Unknown keys are errors.
+func LoadPipeline(path string) (*Pipeline, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var pipeline Pipeline
+	if strings.ToLower(filepath.Ext(path)) == ".json" {
+		decoder := json.NewDecoder(bytes.NewReader(data))
+		decoder.DisallowUnknownFields()
+		err = decoder.Decode(&pipeline)
+	} else {
+		decoder := yaml.NewDecoder(bytes.NewReader(data))
+		decoder.KnownFields(true)
+		err = decoder.Decode(&pipeline)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	pipeline.Dir = filepath.Dir(path)
+	return &pipeline, nil
+}
+
+// Options validates the pipeline and converts it, loading any masks. All
+// the problems found are reported together, as *ValidationError values
+// joined with errors.Join.
+func (pipeline *Pipeline) Options() (Options, error) {
+	var errs []error
+	options := Options{
+		InputSize:   int(pipeline.InputSize),
+		Pyramid:     pipeline.Pyramid,
+		Refine:      pipeline.Refine,
+		Tile:        pipeline.Tile,
+		Antialias:   pipeline.Antialias,
+		RefineEvery: pipeline.RefineEvery,
+		OutputSize:  pipeline.OutputSize,
+		Seed:        pipeline.Seed,
+		Workers:     pipeline.Workers,
+		Search:      pipeline.Search,
+	}
+	filter, err := ParseFilter(pipeline.Filter)
+	if err != nil {
+		errs = append(errs, err)
+	}
+	options.Filter = filter
+	background, err := ParseBackground(pipeline.Background)
+	if err != nil {
+		errs = append(errs, err)
+	}
+	options.Background = background
+	for i, s := range pipeline.Stages {
+		stage, err := s.stage(i+1, pipeline.Dir)
+		errs = append(errs, err)
+		options.Stages = append(options.Stages, stage)
+	}
+	errs = append(errs, options.Validate())
+
+	// report by stage, in the order of the file
+	found := ValidationErrors(errors.Join(errs...))
+	sort.SliceStable(found, func(i, j int) bool { return found[i].Stage < found[j].Stage })
+	errs = errs[:0]
+	for _, err := range found {
+		errs = append(errs, err)
+	}
+	if err := errors.Join(errs...); err != nil {
+		return options, err
+	}
+	return options, nil
+}
+
+// stage converts stage number index, reporting every field that does not
+// parse. Range checks are left to Options.Validate.
+func (s *PipelineStage) stage(index int, dir string) (Stage, error) {
+	var errs []error
+	fail := func(err *ValidationError) {
+		err.Stage = index
+		errs = append(errs, err)
+	}
+	stage := Stage{
+		Count:    s.Count,
+		Alpha:    128,
+		Repeat:   s.Repeat,
+		Search:   s.Search,
+		MinSize:  s.MinSize,
+		MaxSize:  s.MaxSize,
+		MinAlpha: s.MinAlpha,
+		MaxAlpha: s.MaxAlpha,
+		Mode:     ShapeTypeTriangle,
+	}
+	if s.Alpha != nil {
+		stage.Alpha = int(*s.Alpha)
+	}
+	if s.Shape != "" && len(s.Shapes) > 0 {
+		fail(invalid("shapes", nil, "cannot be used with shape", "list every type under shapes"))
+	}
+	if s.Shape != "" {
+		t, err := ParseShapeType(s.Shape)
+		if err != nil {
+			fail(err.(*ValidationError))
+		}
+		stage.Mode = t
+	}
+	for _, name := range s.Shapes {
+		t, err := ParseShapeType(name)
+		if err != nil {
+			fail(err.(*ValidationError))
+			continue
+		}
+		stage.Modes = append(stage.Modes, t)
+	}
+	if len(stage.Modes) == 1 {
+		stage.Mode, stage.Modes = stage.Modes[0], nil
+	}
+	blend, err := ParseBlendMode(s.Blend)
+	if err != nil {
+		fail(err.(*ValidationError))
+	}
+	stage.Blend = blend
+	if s.Mask != "" {
+		path := s.Mask
+		if !filepath.IsAbs(path) {
+			path = filepath.Join(dir, path)
+		}
+		mask, err := LoadImage(path)
+		if err != nil {
+			fail(invalid("mask", s.Mask, err.Error(), "mask paths are relative to the pipeline file"))
+		}
+		stage.Mask = mask
+	}
+	return stage, errors.Join(errs...)
+}
@@ -0,0 +1,157 @@
+package primitive
+
+import (
+	"image"
+	"image/png"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func writeTestFile(t *testing.T, dir, name, data string) string {
+	path := filepath.Join(dir, name)
+	if err := os.WriteFile(path, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// TestLoadPipeline loads the same pipeline written as YAML and as JSON.
+func TestLoadPipeline(t *testing.T) {
+	dir := t.TempDir()
+	mask, err := os.Create(filepath.Join(dir, "mask.png"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	gray := image.NewGray(image.Rect(0, 0, 4, 4))
+	for i := range gray.Pix {
+		gray.Pix[i] = 255
+	}
+	png.Encode(mask, gray)
+	mask.Close()
+
+	yaml := writeTestFile(t, dir, "p.yaml", `
+input_size: auto
+filter: lanczos
+refine: 1
+background: none
+seed: 7
+search: {candidates: 500}
+stages:
+  - count: 10
+    shape: rect
+    alpha: 255
+    min_size: 4
+  - count: 20
+    shapes: [triangle, 3]
+    alpha: auto
+    min_alpha: 32
+    mask: mask.png
+    blend: screen
+`)
+	json := writeTestFile(t, dir, "p.json", `{
+	"input_size": "auto", "filter": "lanczos", "refine": 1, "background": "none", "seed": 7,
+	"search": {"candidates": 500},
+	"stages": [
+		{"count": 10, "shape": "rect", "alpha": 255, "min_size": 4},
+		{"count": 20, "shapes": ["triangle", "3"], "alpha": "auto", "min_alpha": 32,
+		 "mask": "mask.png", "blend": "screen"}
+	]
+}`)
+	want := Options{
+		InputSize:  InputSizeAuto,
+		Filter:     FilterLanczos,
+		Refine:     1,
+		Background: &Color{},
+		Seed:       7,
+		Search:     Search{Candidates: 500},
+		Stages: []Stage{
+			{Count: 10, Mode: ShapeTypeRectangle, Alpha: 255, MinSize: 4},
+			{Count: 20, Mode: ShapeTypeTriangle, Modes: []ShapeType{ShapeTypeTriangle, ShapeTypeEllipse},
+				Alpha: AlphaAuto, MinAlpha: 32, Blend: BlendScreen},
+		},
+	}
+	for _, path := range []string{yaml, json} {
+		pipeline, err := LoadPipeline(path)
+		if err != nil {
+			t.Fatalf("%s: %v", path, err)
+		}
+		got, err := pipeline.Options()
+		if err != nil {
+			t.Fatalf("%s: %v", path, err)
+		}
+		if got.Stages[1].Mask == nil {
+			t.Errorf("%s: mask not loaded", path)
+		}
+		got.Stages[1].Mask = nil
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: options\n%+v\nwant\n%+v", path, got, want)
+		}
+	}
+}
+
+func TestLoadPipelineErrors(t *testing.T) {
+	tests := []struct {
+		name  string
+		data  string
+		error string
+	}{
+		{"p.yaml", "stages:\n  - count: 1\n    mode: rect\n", "field mode not found"},
+		{"p.json", `{"stages": [{"count": 1, "mode": "rect"}]}`, `unknown field "mode"`},
+		{"p.yaml", "input_size: big\n", `input_size must be a number or "auto", got "big"`},
+		{"p.json", `{"stages": [{"count": 1, "alpha": "most"}]}`, `alpha must be a number or "auto", got "most"`},
+		{"p.yaml", "stages: [\n", "did not find expected node content"},
+	}
+	for _, test := range tests {
+		path := writeTestFile(t, t.TempDir(), test.name, test.data)
+		_, err := LoadPipeline(path)
+		if err == nil || !strings.Contains(err.Error(), test.error) || !strings.HasPrefix(err.Error(), path+": ") {
+			t.Errorf("%s %q: error %v, want %q", test.name, test.data, err, test.error)
+		}
+	}
+	if _, err := LoadPipeline(filepath.Join(t.TempDir(), "missing.yaml")); !os.IsNotExist(err) {
+		t.Errorf("missing file: error %v", err)
+	}
+}
+
+// TestPipelineOptionsErrors checks that Options reports every problem, in
+// stage order, with a hint where there is one.
+func TestPipelineOptionsErrors(t *testing.T) {
+	alpha := AlphaValue(300)
+	pipeline := &Pipeline{
+		Filter:     "lanczoz",
+		Background: "nope",
+		Dir:        t.TempDir(),
+		Stages: []PipelineStage{
+			{Count: 10, Shape: "trangle"},
+			{Count: 0, Shapes: []string{"rect", "hexagon"}, Alpha: &alpha},
+			{Count: 5, Shape: "rect", Shapes: []string{"ellipse"}, Blend: "multipy", Mask: "missing.png"},
+		},
+	}
+	_, err := pipeline.Options()
+	type found struct {
+		Stage int
+		Field string
+		Hint  string
+	}
+	var got []found
+	for _, e := range ValidationErrors(err) {
+		got = append(got, found{e.Stage, e.Field, e.Hint})
+	}
+	want := []found{
+		{0, "filter", "did you mean lanczos?"},
+		{0, "background", `use hex digits like ff8800, or "none" for a transparent canvas`},
+		{1, "shape", "did you mean triangle?"},
+		{2, "shape", "use one of beziers, circle, combo, ellipse, polygon, rect, rotatedellipse, rotatedrect, triangle"},
+		{2, "count", ""},
+		{2, "alpha", "use 0 or auto to choose alpha for each shape"},
+		{3, "shapes", "list every type under shapes"},
+		{3, "blend", "did you mean multiply?"},
+		{3, "mask", "mask paths are relative to the pipeline file"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("errors\n%+v\nwant\n%+v", got, want)
+	}
+}
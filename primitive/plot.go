@@ -0,0 +1,458 @@
+package primitive
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+)
+
+// PlotOptions configures toolpath generation for pen plotters and lasers.
+// Width, Height, HatchSpacing and Feed are expressed in Units ("mm" or "in").
+// Pens, if set, is the most pens to use: the colors with the fewest shapes
+// are drawn with the pen of the nearest color kept.
+type PlotOptions struct {
+	Width, Height float64
+	Units         string
+	Feed          float64
+	HatchSpacing  float64
+	HatchAngle    float64
+	Levels        int
+	Pens          int
+	Optimize      bool
+	PenUp         string
+	PenDown       string
+}
+
+func DefaultPlotOptions() PlotOptions {
+	return PlotOptions{
+		Width:        200,
+		Height:       200,
+		Units:        "mm",
+		Feed:         3000,
+		HatchSpacing: 0.5,
+		HatchAngle:   45,
+		Levels:       4,
+		Optimize:     true,
+		PenUp:        "G0 Z5",
+		PenDown:      "G1 Z0",
+	}
+}
+
+type PlotPoint struct {
+	X, Y float64
+}
+
+type PlotPath []PlotPoint
+
+// PlotPen is a group of paths drawn with one pen, chosen by quantized color.
+type PlotPen struct {
+	Color Color
+	Paths []PlotPath
+}
+
+// Plot converts the shapes into pen paths in bed coordinates (origin at the
+// bottom left). Filled shapes produce their outline plus an optional hatch
+// whose density follows the color's darkness and alpha, Quadratic strokes
+// produce their centerline. The background is left to the paper.
+func (model *Model) Plot(options PlotOptions) []PlotPen {
+	w := float64(model.Target.Bounds().Dx())
+	h := float64(model.Target.Bounds().Dy())
+	f := math.Min(options.Width/w, options.Height/h)
+	segment := 0.5
+	if options.Units == "in" {
+		segment = 0.02
+	}
+	transform := func(x, y float64) PlotPoint {
+		return PlotPoint{(x + 0.5) * f, (h - y - 0.5) * f}
+	}
+
+	var pens []*PlotPen
+	var units [][][]PlotPath
+	index := make(map[Color]int)
+	for i, shape := range model.Shapes {
+		c := quantizeColor(model.Colors[i], options.Levels)
+		points, closed := shapeOutline(shape, segment/f)
+		if len(points) < 2 {
+			continue
+		}
+		outline := make(PlotPath, len(points))
+		for j, p := range points {
+			outline[j] = transform(p.X, p.Y)
+		}
+		paths := []PlotPath{outline}
+		if closed && options.HatchSpacing > 0 {
+			paths = append(paths, hatchPath(outline, options.HatchSpacing, options.HatchAngle, model.Colors[i])...)
+		}
+		var unit []PlotPath
+		for _, path := range paths {
+			unit = append(unit, clipPlotPath(path, w*f, h*f)...)
+		}
+		if len(unit) == 0 {
+			continue
+		}
+		j, ok := index[c]
+		if !ok {
+			j = len(pens)
+			index[c] = j
+			pens = append(pens, &PlotPen{Color: c})
+			units = append(units, nil)
+		}
+		units[j] = append(units[j], unit)
+	}
+
+	if options.Pens > 0 && len(pens) > options.Pens {
+		pens, units = mergePlotPens(pens, units, options.Pens)
+	}
+
+	result := make([]PlotPen, len(pens))
+	for i, pen := range pens {
+		if options.Optimize {
+			units[i] = orderPlotUnits(units[i])
+		}
+		for _, unit := range units[i] {
+			pen.Paths = append(pen.Paths, unit...)
+		}
+		result[i] = *pen
+	}
+	return result
+}
+
+// mergePlotPens keeps the n pens with the most units, in their order, and
+// hands the units of the others to the kept pen of the nearest color.
+func mergePlotPens(pens []*PlotPen, units [][][]PlotPath, n int) ([]*PlotPen, [][][]PlotPath) {
+	order := make([]int, len(pens))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(a, b int) bool {
+		return len(units[order[a]]) > len(units[order[b]])
+	})
+	keep := make([]bool, len(pens))
+	for _, i := range order[:n] {
+		keep[i] = true
+	}
+	var kept []int
+	for i := range pens {
+		if keep[i] {
+			kept = append(kept, i)
+		}
+	}
+	for i, pen := range pens {
+		if keep[i] {
+			continue
+		}
+		best, bestDist := kept[0], -1
+		for _, j := range kept {
+			a, b := pen.Color, pens[j].Color
+			d := (a.R-b.R)*(a.R-b.R) + (a.G-b.G)*(a.G-b.G) + (a.B-b.B)*(a.B-b.B)
+			if bestDist < 0 || d < bestDist {
+				best, bestDist = j, d
+			}
+		}
+		units[best] = append(units[best], units[i]...)
+	}
+	resultPens := make([]*PlotPen, len(kept))
+	resultUnits := make([][][]PlotPath, len(kept))
+	for k, i := range kept {
+		resultPens[k], resultUnits[k] = pens[i], units[i]
+	}
+	return resultPens, resultUnits
+}
+
+func quantizeColor(c Color, levels int) Color {
+	if levels < 2 {
+		return Color{0, 0, 0, 255}
+	}
+	step := 255.0 / float64(levels-1)
+	q := func(x int) int {
+		return int(math.Round(math.Round(float64(x)/step) * step))
+	}
+	return Color{q(c.R), q(c.G), q(c.B), 255}
+}
+
+// shapeOutline returns the outline (or centerline) of a shape in target
+// coordinates and whether it is a closed, filled path. Curves are
+// flattened into segments of roughly the given length.
+func shapeOutline(shape Shape, segment float64) ([]PlotPoint, bool) {
+	curve := func(length float64) int {
+		return clampInt(int(length/segment), 16, 720)
+	}
+	ellipse := func(x, y, rx, ry, angle float64) []PlotPoint {
+		n := curve(2 * math.Pi * math.Max(rx, ry))
+		points := make([]PlotPoint, n+1)
+		for i := 0; i <= n; i++ {
+			t := 2 * math.Pi * float64(i) / float64(n)
+			px, py := rotate(rx*math.Cos(t), ry*math.Sin(t), angle)
+			points[i] = PlotPoint{x + px, y + py}
+		}
+		return points
+	}
+	switch s := shape.(type) {
+	case *Triangle:
+		return []PlotPoint{
+			{float64(s.X1), float64(s.Y1)},
+			{float64(s.X2), float64(s.Y2)},
+			{float64(s.X3), float64(s.Y3)},
+			{float64(s.X1), float64(s.Y1)},
+		}, true
+	case *Rectangle:
+		x1, y1, x2, y2 := s.bounds()
+		fx1, fy1 := float64(x1), float64(y1)
+		fx2, fy2 := float64(x2+1), float64(y2+1)
+		return []PlotPoint{{fx1, fy1}, {fx2, fy1}, {fx2, fy2}, {fx1, fy2}, {fx1, fy1}}, true
+	case *Ellipse:
+		return ellipse(float64(s.X), float64(s.Y), float64(s.Rx), float64(s.Ry), 0), true
+	case *RotatedEllipse:
+		return ellipse(s.X, s.Y, s.Rx, s.Ry, radians(s.Angle)), true
+	case *RotatedRectangle:
+		sx, sy := float64(s.Sx)/2, float64(s.Sy)/2
+		angle := radians(float64(s.Angle))
+		var points []PlotPoint
+		for _, c := range [][2]float64{{-sx, -sy}, {sx, -sy}, {sx, sy}, {-sx, sy}, {-sx, -sy}} {
+			x, y := rotate(c[0], c[1], angle)
+			points = append(points, PlotPoint{float64(s.X) + x, float64(s.Y) + y})
+		}
+		return points, true
+	case *Polygon:
+		var points []PlotPoint
+		for i := 0; i <= s.Order; i++ {
+			points = append(points, PlotPoint{s.X[i%s.Order], s.Y[i%s.Order]})
+		}
+		return points, true
+	case *Quadratic:
+		n := curve(math.Hypot(s.X2-s.X1, s.Y2-s.Y1) + math.Hypot(s.X3-s.X2, s.Y3-s.Y2))
+		points := make([]PlotPoint, n+1)
+		for i := 0; i <= n; i++ {
+			t := float64(i) / float64(n)
+			a, b, c := (1-t)*(1-t), 2*(1-t)*t, t*t
+			points[i] = PlotPoint{
+				a*s.X1 + b*s.X2 + c*s.X3,
+				a*s.Y1 + b*s.Y2 + c*s.Y3}
+		}
+		return points, false
+	}
+	return nil, false
+}
+
+// hatchPath fills a closed path with parallel lines. The spacing is the
+// minimum spacing, used for fully opaque black; lighter or more transparent
+// colors get sparser lines and very light ones get none.
+func hatchPath(outline PlotPath, spacing, angle float64, c Color) []PlotPath {
+	darkness := 1 - (0.299*float64(c.R)+0.587*float64(c.G)+0.114*float64(c.B))/255
+	density := darkness * float64(c.A) / 255
+	if density < 0.05 {
+		return nil
+	}
+	spacing /= density
+	theta := radians(angle)
+	rotated := make(PlotPath, len(outline))
+	y0, y1 := math.Inf(1), math.Inf(-1)
+	for i, p := range outline {
+		x, y := rotate(p.X, p.Y, -theta)
+		rotated[i] = PlotPoint{x, y}
+		y0 = math.Min(y0, y)
+		y1 = math.Max(y1, y)
+	}
+	var result []PlotPath
+	reverse := false
+	for y := math.Floor(y0/spacing)*spacing + spacing/2; y < y1; y += spacing {
+		var xs []float64
+		for i := 1; i < len(rotated); i++ {
+			a, b := rotated[i-1], rotated[i]
+			if (a.Y <= y) == (b.Y <= y) {
+				continue
+			}
+			xs = append(xs, a.X+(y-a.Y)*(b.X-a.X)/(b.Y-a.Y))
+		}
+		sort.Float64s(xs)
+		var lines []PlotPath
+		for i := 0; i+1 < len(xs); i += 2 {
+			ax, ay := rotate(xs[i], y, theta)
+			bx, by := rotate(xs[i+1], y, theta)
+			lines = append(lines, PlotPath{{ax, ay}, {bx, by}})
+		}
+		if reverse {
+			reversePlotUnit(lines)
+		}
+		reverse = !reverse
+		result = append(result, lines...)
+	}
+	return result
+}
+
+// clipPlotPath clips a path to the rectangle (0, 0) - (w, h), splitting it
+// where it leaves and re-enters.
+func clipPlotPath(path PlotPath, w, h float64) []PlotPath {
+	var result []PlotPath
+	var current PlotPath
+	for i := 1; i < len(path); i++ {
+		a, b := path[i-1], path[i]
+		t0, t1 := 0.0, 1.0
+		dx, dy := b.X-a.X, b.Y-a.Y
+		visible := true
+		for _, e := range [][2]float64{{-dx, a.X}, {dx, w - a.X}, {-dy, a.Y}, {dy, h - a.Y}} {
+			p, q := e[0], e[1]
+			if p == 0 {
+				if q < 0 {
+					visible = false
+				}
+				continue
+			}
+			r := q / p
+			if p < 0 {
+				t0 = math.Max(t0, r)
+			} else {
+				t1 = math.Min(t1, r)
+			}
+		}
+		if !visible || t0 > t1 {
+			if len(current) > 1 {
+				result = append(result, current)
+			}
+			current = nil
+			continue
+		}
+		p0 := PlotPoint{a.X + t0*dx, a.Y + t0*dy}
+		p1 := PlotPoint{a.X + t1*dx, a.Y + t1*dy}
+		if len(current) == 0 || t0 > 0 {
+			if len(current) > 1 {
+				result = append(result, current)
+			}
+			current = PlotPath{p0}
+		}
+		current = append(current, p1)
+		if t1 < 1 {
+			result = append(result, current)
+			current = nil
+		}
+	}
+	if len(current) > 1 {
+		result = append(result, current)
+	}
+	return result
+}
+
+func reversePlotUnit(unit []PlotPath) {
+	for i, j := 0, len(unit)-1; i < j; i, j = i+1, j-1 {
+		unit[i], unit[j] = unit[j], unit[i]
+	}
+	for _, path := range unit {
+		for i, j := 0, len(path)-1; i < j; i, j = i+1, j-1 {
+			path[i], path[j] = path[j], path[i]
+		}
+	}
+}
+
+// orderPlotUnits greedily orders the units (a shape's outline plus its
+// hatch) by nearest neighbour, reversing a unit when its end is closer.
+func orderPlotUnits(units [][]PlotPath) [][]PlotPath {
+	dist := func(a, b PlotPoint) float64 {
+		return math.Hypot(a.X-b.X, a.Y-b.Y)
+	}
+	first := func(unit []PlotPath) PlotPoint {
+		return unit[0][0]
+	}
+	last := func(unit []PlotPath) PlotPoint {
+		path := unit[len(unit)-1]
+		return path[len(path)-1]
+	}
+	result := make([][]PlotPath, 0, len(units))
+	used := make([]bool, len(units))
+	var position PlotPoint
+	for len(result) < len(units) {
+		best, bestDist, bestReverse := -1, 0.0, false
+		for i, unit := range units {
+			if used[i] {
+				continue
+			}
+			if d := dist(position, first(unit)); best < 0 || d < bestDist {
+				best, bestDist, bestReverse = i, d, false
+			}
+			if d := dist(position, last(unit)); d < bestDist {
+				best, bestDist, bestReverse = i, d, true
+			}
+		}
+		used[best] = true
+		unit := units[best]
+		if bestReverse {
+			reversePlotUnit(unit)
+		}
+		result = append(result, unit)
+		position = last(unit)
+	}
+	return result
+}
+
+// GCode returns the toolpaths as G-code. Pen changes pause the machine with
+// M0 so the pen can be swapped; PenUp and PenDown raise and lower the tool.
+// The feed rate is set in the header, as controllers like GRBL refuse a G1
+// before they have one, and again on the first move of each path in case
+// PenUp or PenDown changed it.
+func (model *Model) GCode(options PlotOptions) string {
+	var lines []string
+	units := "G21"
+	if options.Units == "in" {
+		units = "G20"
+	}
+	lines = append(lines, "; primitive", units, "G90", fmt.Sprintf("F%g", options.Feed), options.PenUp)
+	for i, pen := range model.Plot(options) {
+		c := pen.Color
+		lines = append(lines, fmt.Sprintf("; pen %d #%02x%02x%02x", i+1, c.R, c.G, c.B))
+		if i > 0 {
+			lines = append(lines, "G0 X0 Y0", fmt.Sprintf("M0 ; change to pen %d #%02x%02x%02x", i+1, c.R, c.G, c.B))
+		}
+		for _, path := range pen.Paths {
+			lines = append(lines, fmt.Sprintf("G0 X%.3f Y%.3f", path[0].X, path[0].Y))
+			lines = append(lines, options.PenDown)
+			for j, p := range path[1:] {
+				if j == 0 {
+					lines = append(lines, fmt.Sprintf("G1 X%.3f Y%.3f F%g", p.X, p.Y, options.Feed))
+				} else {
+					lines = append(lines, fmt.Sprintf("G1 X%.3f Y%.3f", p.X, p.Y))
+				}
+			}
+			lines = append(lines, options.PenUp)
+		}
+	}
+	lines = append(lines, "G0 X0 Y0", "M2")
+	return strings.Join(lines, "\n") + "\n"
+}
+
+// hpglPens is the number of pens in an HPGL plotter's carousel, which SP
+// selects from 1.
+const hpglPens = 8
+
+// HPGL returns the toolpaths as HPGL, selecting one plotter pen per
+// quantized color, with at most hpglPens pens whatever options.Pens says.
+// Feed rate is converted to a VS velocity in cm/s.
+func (model *Model) HPGL(options PlotOptions) string {
+	if options.Pens <= 0 || options.Pens > hpglPens {
+		options.Pens = hpglPens
+	}
+	unit := 40.0 // plotter units per mm
+	mm := options.Feed / 60
+	if options.Units == "in" {
+		unit = 1016
+		mm *= 25.4
+	}
+	var commands []string
+	commands = append(commands, "IN", fmt.Sprintf("VS%g", math.Max(1, math.Round(mm/10))))
+	pt := func(p PlotPoint) string {
+		return fmt.Sprintf("%d,%d", int(math.Round(p.X*unit)), int(math.Round(p.Y*unit)))
+	}
+	for i, pen := range model.Plot(options) {
+		commands = append(commands, fmt.Sprintf("SP%d", i+1))
+		for _, path := range pen.Paths {
+			commands = append(commands, "PU"+pt(path[0]))
+			var points []string
+			for _, p := range path[1:] {
+				points = append(points, pt(p))
+			}
+			commands = append(commands, "PD"+strings.Join(points, ","))
+		}
+		commands = append(commands, "PU")
+	}
+	commands = append(commands, "SP0")
+	return strings.Join(commands, ";\n") + ";\n"
+}
@@ -2,6 +2,8 @@ package primitive
 
 import (
 	"fmt"
+	"image"
+	"math"
 	"strings"
 
 	"github.com/fogleman/gg"
@@ -111,7 +113,7 @@ func cross3(x1, y1, x2, y2, x3, y3 float64) float64 {
 func (p *Polygon) Rasterize() []Scanline {
 	var path raster.Path
 	for i := 0; i <= p.Order; i++ {
-		f := fixp(p.X[i%p.Order], p.Y[i%p.Order])
+		f := p.Worker.point(p.X[i%p.Order], p.Y[i%p.Order])
 		if i == 0 {
 			path.Start(f)
 		} else {
@@ -120,3 +122,12 @@ func (p *Polygon) Rasterize() []Scanline {
 	}
 	return fillPath(p.Worker, path)
 }
+
+func (p *Polygon) Bounds() image.Rectangle {
+	x1, y1, x2, y2 := p.X[0], p.Y[0], p.X[0], p.Y[0]
+	for i := 1; i < p.Order; i++ {
+		x1, x2 = math.Min(x1, p.X[i]), math.Max(x2, p.X[i])
+		y1, y2 = math.Min(y1, p.Y[i]), math.Max(y2, p.Y[i])
+	}
+	return boundsOf(p.Worker, x1, y1, x2, y2)
+}
@@ -0,0 +1,191 @@
+package primitive
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+)
+
+// PruneStrategy is how Prune picks the shapes to remove. PruneGreedy ranks
+// every shape once by how much removing it alone raises the score and
+// removes them in that order. PruneIterative ranks the remaining shapes
+// again after each removal, which is slower but accounts for shapes that
+// only mattered together.
+type PruneStrategy int
+
+const (
+	PruneGreedy PruneStrategy = iota
+	PruneIterative
+)
+
+var pruneStrategyNames = []string{"greedy", "iterative"}
+
+func (s PruneStrategy) String() string {
+	if s < 0 || int(s) >= len(pruneStrategyNames) {
+		return fmt.Sprintf("PruneStrategy(%d)", int(s))
+	}
+	return pruneStrategyNames[s]
+}
+
+// ParsePruneStrategy accepts a strategy name, with "" meaning greedy.
+func ParsePruneStrategy(s string) (PruneStrategy, error) {
+	if s == "" {
+		return PruneGreedy, nil
+	}
+	for i, name := range pruneStrategyNames {
+		if s == name {
+			return PruneStrategy(i), nil
+		}
+	}
+	return 0, invalid("strategy", s, "unknown prune strategy", suggest(s, pruneStrategyNames))
+}
+
+// PruneOptions configures Prune. Shapes are removed until Count remain or
+// until removing another would raise the score by more than MaxLoss over
+// the unpruned model, whichever comes first. At least one must be set.
+type PruneOptions struct {
+	Strategy PruneStrategy
+	Count    int
+	MaxLoss  float64
+}
+
+// Validate checks the options, reporting every problem found joined
+// together.
+func (options *PruneOptions) Validate() error {
+	var errs []error
+	if options.Strategy < PruneGreedy || options.Strategy > PruneIterative {
+		errs = append(errs, invalid("strategy", int(options.Strategy), "unknown prune strategy", "use greedy or iterative"))
+	}
+	if options.Count < 0 {
+		errs = append(errs, invalid("count", options.Count, "must be >= 0", ""))
+	}
+	if options.MaxLoss < 0 {
+		errs = append(errs, invalid("max_loss", options.MaxLoss, "must be >= 0", ""))
+	}
+	if options.Count == 0 && options.MaxLoss == 0 {
+		errs = append(errs, invalid("count", nil, "a shape count or an allowed score loss is required", ""))
+	}
+	return errors.Join(errs...)
+}
+
+// Prune removes the shapes that contribute least to the model, keeping the
+// order of the others, and returns the number removed. Every removal is
+// checked by recompositing the whole image and scoring it with
+// differenceFull. If ctx is cancelled Prune stops, leaving the model
+// consistent.
+func (model *Model) Prune(ctx context.Context, options PruneOptions) (int, error) {
+	if err := options.Validate(); err != nil {
+		return 0, err
+	}
+	r := newRefiner(model)
+	r.recomposite()
+	start := model.Score
+	ids := make([]int, len(r.layers)) // original indices of the layers
+	for i := range ids {
+		ids[i] = i
+	}
+
+	// remove tries to remove the layer at i, reporting false if that
+	// loses too much
+	remove := func(i int) bool {
+		score := r.scoreWithout(i)
+		if options.MaxLoss > 0 && score-start > options.MaxLoss {
+			return false
+		}
+		r.delete(i)
+		ids = append(ids[:i], ids[i+1:]...)
+		model.Current, r.buffer = r.buffer, model.Current
+		model.Score = score
+		return true
+	}
+	done := func() bool {
+		return ctx.Err() != nil || len(r.layers) == 0 ||
+			(options.Count > 0 && len(r.layers) <= options.Count)
+	}
+
+	removed := 0
+	switch options.Strategy {
+	case PruneGreedy:
+		costs := r.removalScores()
+		order := make([]int, len(costs))
+		for i := range order {
+			order[i] = i
+		}
+		sort.SliceStable(order, func(a, b int) bool { return costs[order[a]] < costs[order[b]] })
+		for _, id := range order {
+			if done() {
+				break
+			}
+			i := 0
+			for ids[i] != id {
+				i++
+			}
+			if !remove(i) {
+				break
+			}
+			removed++
+		}
+	case PruneIterative:
+		for !done() {
+			costs := r.removalScores()
+			best := 0
+			for i, cost := range costs {
+				if cost < costs[best] {
+					best = i
+				}
+			}
+			if !remove(best) {
+				break
+			}
+			removed++
+		}
+	}
+
+	r.recomposite()
+	model.Meta.Pruned += removed
+	return removed, ctx.Err()
+}
+
+// removalScores returns the score of the image without each shape,
+// estimated from the rows it covers.
+func (r *refiner) removalScores() []float64 {
+	model := r.model
+	copy(r.below.Pix, r.background.Pix)
+	scores := make([]float64, len(r.layers))
+	for i, l := range r.layers {
+		r.index = i
+		scores[i] = r.composite(nil, Color{})
+		drawBlendLines(r.below, model.Colors[i], l.lines, model.Blends[i])
+	}
+	return scores
+}
+
+// scoreWithout composites every shape but the one at index i into r.buffer
+// and returns its score.
+func (r *refiner) scoreWithout(i int) float64 {
+	model := r.model
+	copy(r.buffer.Pix, r.background.Pix)
+	for j, l := range r.layers {
+		if j != i {
+			drawBlendLines(r.buffer, model.Colors[j], l.lines, model.Blends[j])
+		}
+	}
+	return differenceFull(model.Target, r.buffer)
+}
+
+// delete removes the shape at index i from the model and the layers.
+func (r *refiner) delete(i int) {
+	model := r.model
+	r.layers = append(r.layers[:i], r.layers[i+1:]...)
+	model.Shapes = append(model.Shapes[:i], model.Shapes[i+1:]...)
+	model.Colors = append(model.Colors[:i], model.Colors[i+1:]...)
+	model.Scores = append(model.Scores[:i], model.Scores[i+1:]...)
+	model.Blends = append(model.Blends[:i], model.Blends[i+1:]...)
+	model.layerLimits = append(model.layerLimits[:i], model.layerLimits[i+1:]...)
+	for j, end := range model.Meta.StageEnds {
+		if end > i {
+			model.Meta.StageEnds[j] = end - 1
+		}
+	}
+}
@@ -0,0 +1,182 @@
+package primitive
+
+import (
+	"context"
+	"image"
+)
+
+// The pyramid holds coarser copies of the target so that the early, large
+// shapes can be searched on far fewer pixels. Level k is 2^k times smaller
+// than the target. Each model worker keeps a worker per coarse level that
+// shares its random source; the best shape found at a coarse level is
+// promoted one level at a time, with a short hill climb at each, before it
+// is added at full resolution.
+
+const (
+	// pyramidMinShape is the size, in pixels of a level, below which
+	// shapes are too small to be searched at that level
+	pyramidMinShape = 16
+
+	// pyramidMinSize is the shortest side a coarse level may have
+	pyramidMinSize = 16
+)
+
+// SetPyramid enables coarse-to-fine search with up to levels coarse levels,
+// fewer if the target is too small for them. 0 disables it.
+func (model *Model) SetPyramid(levels int) {
+	model.pyramid = nil
+	target := model.Target
+	for k := 0; k < levels; k++ {
+		size := target.Bounds().Size()
+		if minInt(size.X, size.Y)/2 < pyramidMinSize {
+			break
+		}
+		target = halveRGBA(target)
+		model.pyramid = append(model.pyramid, target)
+	}
+	for _, worker := range model.Workers {
+		worker.levels = nil
+		for _, target := range model.pyramid {
+			w := NewWorker(target)
+			w.Rnd = worker.Rnd
+			w.Antialias = worker.Antialias
+			worker.levels = append(worker.levels, w)
+		}
+	}
+	model.level = len(model.pyramid)
+}
+
+// startStage goes back to the coarsest level whose shapes can still meet
+// the stage's size limits.
+func (model *Model) startStage(l limits) {
+	model.level = len(model.pyramid)
+	for model.level > 0 && l.MaxSize > 0 && l.MaxSize>>model.level < pyramidMinShape {
+		model.level--
+	}
+}
+
+// updateLevel moves to a finer level once the shapes being added are too
+// small for the current one. Levels never get coarser within a stage.
+func (model *Model) updateLevel(shape Shape) {
+	size := linesSize(shape.Rasterize())
+	for model.level > 0 && size < pyramidMinShape<<model.level {
+		model.level--
+	}
+}
+
+// setLimits sets the limits of the workers and their coarse levels.
+func (model *Model) setLimits(l limits) {
+	model.limits = l
+	scaled := make([]limits, len(model.pyramid))
+	for k := range scaled {
+		scaled[k] = l.scaled(k + 1)
+	}
+	for _, worker := range model.Workers {
+		worker.limits = l
+		for k, w := range worker.levels {
+			w.limits = scaled[k]
+		}
+	}
+}
+
+// scaled returns the limits for pyramid level k.
+func (l limits) scaled(k int) limits {
+	s := 1 << k
+	l.MinSize /= s
+	if l.MaxSize > 0 {
+		l.MaxSize = (l.MaxSize + s - 1) / s
+	}
+	if l.Mask != nil {
+		b := l.Mask.Bounds()
+		mask := image.NewGray(image.Rect(0, 0, maxInt(b.Dx()/s, 1), maxInt(b.Dy()/s, 1)))
+		for y := 0; y < mask.Rect.Dy(); y++ {
+			for x := 0; x < mask.Rect.Dx(); x++ {
+				mask.Pix[mask.PixOffset(x, y)] = l.Mask.Pix[l.Mask.PixOffset(x*s+s/2, y*s+s/2)]
+			}
+		}
+		l.Mask = mask
+	}
+	return l
+}
+
+// searchPyramid runs the search at the current coarse level and promotes
+// the result to full resolution, falling back to a full resolution search
+// if that fails. It returns the state, the number of shapes evaluated and
+// how many of them were rejected by their bounds.
+func (model *Model) searchPyramid(ctx context.Context, t ShapeType, alpha int, search Search) (*State, int, int) {
+	level := model.level
+	currents := []*image.RGBA{model.Current}
+	for k := 1; k <= level; k++ {
+		currents = append(currents, halveRGBA(currents[k-1]))
+	}
+	workers := make([]*Worker, len(model.Workers))
+	for i, worker := range model.Workers {
+		workers[i] = worker.levels[level-1]
+	}
+	score := differenceFull(model.pyramid[level-1], currents[level])
+	state := model.runWorkers(ctx, workers, currents[level], score,
+		t, alpha, search.Candidates, search.Age, search.Restarts)
+	counter, rejected := 0, 0
+	for _, worker := range workers {
+		counter += worker.Counter
+		rejected += worker.Rejected
+	}
+
+	promoted := state != nil
+	for k := level - 1; k >= 0 && promoted && ctx.Err() == nil; k-- {
+		worker, score := model.Workers[0], model.Score
+		if k > 0 {
+			worker = worker.levels[k-1]
+			score = differenceFull(model.pyramid[k-1], currents[k])
+		}
+		worker.Init(currents[k], score)
+		var shape Shape
+		if shape, promoted = promote(state.Shape, worker); !promoted {
+			break
+		}
+		state = &State{worker, shape, state.Alpha, state.MutateAlpha, -1}
+		worker.ctx = ctx
+		state = HillClimb(state, maxInt(search.Age/2, 1)).(*State)
+		worker.ctx = context.Background()
+		counter += worker.Counter
+		rejected += worker.Rejected
+	}
+	if ctx.Err() == nil && (!promoted || state.Energy() > model.Score) {
+		// the shape couldn't be promoted, or broke the limits or made
+		// things worse, which happens with small or masked stages: search
+		// at full resolution
+		state = model.runWorkers(ctx, model.Workers, model.Current, model.Score,
+			t, alpha, search.Candidates, search.Age, search.Restarts)
+		for _, worker := range model.Workers {
+			counter += worker.Counter
+			rejected += worker.Rejected
+		}
+	}
+	return state, counter, rejected
+}
+
+// promote rebuilds a shape found at one level for the next finer level,
+// whose worker is given, moving coordinates to the middle of the finer
+// pixels. It reports false if the shape can't be rebuilt.
+func promote(shape Shape, worker *Worker) (Shape, bool) {
+	return transformShape(shape, worker, 2, 0.5, 0.5)
+}
+
+// halveRGBA returns im at half the size, averaging blocks of 2x2 pixels.
+// An odd last row or column is dropped.
+func halveRGBA(im *image.RGBA) *image.RGBA {
+	size := im.Bounds().Size()
+	dst := image.NewRGBA(image.Rect(0, 0, maxInt(size.X/2, 1), maxInt(size.Y/2, 1)))
+	for y := 0; y < dst.Rect.Dy(); y++ {
+		for x := 0; x < dst.Rect.Dx(); x++ {
+			a := im.PixOffset(im.Rect.Min.X+2*x, im.Rect.Min.Y+2*y)
+			b := a + im.Stride
+			i := dst.PixOffset(x, y)
+			for k := 0; k < 4; k++ {
+				sum := int(im.Pix[a+k]) + int(im.Pix[a+4+k]) + int(im.Pix[b+k]) + int(im.Pix[b+4+k])
+				dst.Pix[i+k] = uint8((sum + 2) / 4)
+			}
+		}
+	}
+	return dst
+}
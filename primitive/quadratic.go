@@ -2,6 +2,8 @@ package primitive
 
 import (
 	"fmt"
+	"image"
+	"math"
 	"strings"
 
 	"github.com/fogleman/gg"
@@ -90,11 +92,22 @@ func (q *Quadratic) Valid() bool {
 
 func (q *Quadratic) Rasterize() []Scanline {
 	var path raster.Path
-	p1 := fixp(q.X1, q.Y1)
-	p2 := fixp(q.X2, q.Y2)
-	p3 := fixp(q.X3, q.Y3)
+	p1 := q.Worker.point(q.X1, q.Y1)
+	p2 := q.Worker.point(q.X2, q.Y2)
+	p3 := q.Worker.point(q.X3, q.Y3)
 	path.Start(p1)
 	path.Add2(p2, p3)
 	width := fix(q.Width)
 	return strokePath(q.Worker, path, width, raster.RoundCapper, raster.RoundJoiner)
 }
+
+func (q *Quadratic) Bounds() image.Rectangle {
+	// the curve stays within its control points, and the stroke and its
+	// round caps within half its width of the curve
+	d := q.Width / 2
+	x1 := math.Min(q.X1, math.Min(q.X2, q.X3)) - d
+	y1 := math.Min(q.Y1, math.Min(q.Y2, q.Y3)) - d
+	x2 := math.Max(q.X1, math.Max(q.X2, q.X3)) + d
+	y2 := math.Max(q.Y1, math.Max(q.Y2, q.Y3)) + d
+	return boundsOf(q.Worker, x1, y1, x2, y2)
+}
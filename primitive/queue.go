@@ -0,0 +1,173 @@
+package primitive
+
+import (
+	"context"
+	"image"
+	"sync"
+)
+
+// The search for each shape is split into tasks that the workers take from
+// a shared queue: batches of random candidates for each hill climb
+// restart, after which the worker that finishes the last batch of a
+// restart climbs from its best candidate. Each worker has its own deque,
+// dealt the tasks in restart order; it takes from the front of its own and
+// steals from the back of the others' once it runs dry, so no worker sits
+// idle while there is work left in the step. Tasks seed the worker's random
+// source from their index, which makes the shape found independent of how
+// the tasks end up spread over the workers, and of their number.
+
+// searchBatch is the number of random candidates in a task.
+const searchBatch = 50
+
+type searchTask struct {
+	restart int
+	index   int
+	count   int
+}
+
+type searchDeque struct {
+	mu    sync.Mutex
+	tasks []searchTask
+}
+
+type searchQueue []searchDeque
+
+// next returns the next task for worker i, stealing one if it has none.
+func (q searchQueue) next(i int) (searchTask, bool) {
+	for k := 0; k < len(q); k++ {
+		d := &q[(i+k)%len(q)]
+		d.mu.Lock()
+		if n := len(d.tasks); n > 0 {
+			var task searchTask
+			if k == 0 {
+				task, d.tasks = d.tasks[0], d.tasks[1:]
+			} else {
+				task, d.tasks = d.tasks[n-1], d.tasks[:n-1]
+			}
+			d.mu.Unlock()
+			return task, true
+		}
+		d.mu.Unlock()
+	}
+	return searchTask{}, false
+}
+
+// searchRestart collects the best random candidate of a restart's batches.
+type searchRestart struct {
+	mu    sync.Mutex
+	left  int
+	best  *State
+	index int
+}
+
+// offer records a batch's best candidate and reports whether it was the
+// last batch, along with the best of them all. Ties go to the lower index.
+func (r *searchRestart) offer(state *State, index int) (bool, *State) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if state != nil {
+		if r.best == nil || state.Energy() < r.best.Energy() ||
+			(state.Energy() == r.best.Energy() && index < r.index) {
+			r.best, r.index = state, index
+		}
+	}
+	r.left--
+	return r.left == 0, r.best
+}
+
+// runWorkers searches for the best shape to add to current, which has the
+// given score, with m hill climb restarts from the best of n random
+// candidates each. Stopping when ctx is cancelled, it returns the best
+// state found so far, or nil if there is none.
+func (model *Model) runWorkers(ctx context.Context, workers []*Worker, current *image.RGBA, score float64, t ShapeType, a, n, age, m int) *State {
+	wn := len(workers)
+	base := workers[0].Rnd.Int63()
+	batches := (n + searchBatch - 1) / searchBatch
+	queue := make(searchQueue, wn)
+	restarts := make([]searchRestart, m)
+	tasks := 0
+	for r := range restarts {
+		restarts[r].left = batches
+		for b := 0; b < batches; b++ {
+			count := minInt(searchBatch, n-b*searchBatch)
+			d := &queue[tasks%wn]
+			d.tasks = append(d.tasks, searchTask{r, tasks, count})
+			tasks++
+		}
+	}
+
+	results := make([]*State, m)
+	var wg sync.WaitGroup
+	for i, worker := range workers {
+		worker.Init(current, score)
+		worker.ctx = ctx
+		wg.Add(1)
+		go func(i int, worker *Worker) {
+			defer wg.Done()
+			for {
+				task, ok := queue.next(i)
+				if !ok {
+					return
+				}
+				model.acquire()
+				model.runTask(worker, task, restarts, results, base, tasks, t, a, n, age)
+				model.release()
+			}
+		}(i, worker)
+	}
+	wg.Wait()
+
+	var bestState *State
+	for r, state := range results {
+		if state == nil {
+			state = restarts[r].best // cancelled before climbing
+		}
+		if state != nil && (bestState == nil || state.Energy() < bestState.Energy()) {
+			bestState = state
+		}
+	}
+	// leave the random sources in a state that doesn't depend on which
+	// worker ran what, for whatever runs after the step
+	for i, worker := range workers {
+		worker.Rnd.Seed(base + int64(tasks+m+i))
+		worker.ctx = context.Background()
+	}
+	return bestState
+}
+
+// runTask runs a task of runWorkers on the given worker: a batch of random
+// candidates, followed by the hill climb of its restart if it was the
+// restart's last batch.
+func (model *Model) runTask(worker *Worker, task searchTask, restarts []searchRestart, results []*State, base int64, tasks int, t ShapeType, a, n, age int) {
+	var state *State
+	if !worker.canceled() {
+		worker.Rnd.Seed(base + int64(task.index))
+		state = worker.BestRandomState(t, a, task.count)
+	}
+	last, best := restarts[task.restart].offer(state, task.index)
+	if !last || best == nil || worker.canceled() {
+		return
+	}
+	worker.Rnd.Seed(base + int64(tasks+task.restart))
+	if state = adoptState(best, worker); state == nil {
+		return // left unclimbed
+	}
+	before := state.Energy()
+	state = HillClimb(state, age).(*State)
+	debug("hill climb", "candidates", n, "random", before, "age", age, "score", state.Energy())
+	results[task.restart] = state
+}
+
+// adoptState returns state with a copy of its shape for the given worker, as
+// shapes rasterize into and mutate with their worker's buffers, or nil if
+// the shape is of a type the package doesn't know.
+func adoptState(state *State, worker *Worker) *State {
+	if state.Worker == worker {
+		return state
+	}
+	shape, ok := shapeFor(state.Shape, worker)
+	if !ok {
+		return nil
+	}
+	return &State{worker, shape, state.Alpha, state.MutateAlpha, state.Score}
+}
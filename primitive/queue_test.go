@@ -0,0 +1,21 @@
+package primitive
+
+import (
+	"reflect"
+	"testing"
+)
+
+// TestWorkerCountDeterminism checks that a run with a fixed seed finds the
+// same shapes whatever the number of workers sharing its search.
+func TestWorkerCountDeterminism(t *testing.T) {
+	target := testTarget(t, 48)
+	for _, run := range testRuns {
+		want := testRun(t, target, run.options, 1).Scene().Shapes
+		for _, workers := range []int{2, 3, 8} {
+			got := testRun(t, target, run.options, workers).Scene().Shapes
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("%s: %d workers found\n%v\nwant\n%v", run.name, workers, got, want)
+			}
+		}
+	}
+}
@@ -10,7 +10,16 @@ func fix(x float64) fixed.Int26_6 {
 }
 
 func fixp(x, y float64) fixed.Point26_6 {
-	return fixed.Point26_6{fix(x), fix(y)}
+	return fixed.Point26_6{X: fix(x), Y: fix(y)}
+}
+
+// point converts shape coordinates for the rasterizer. With Antialias they
+// are taken as pixel centers, as when shapes are drawn for output.
+func (worker *Worker) point(x, y float64) fixed.Point26_6 {
+	if worker.Antialias {
+		x, y = x+0.5, y+0.5
+	}
+	return fixp(x, y)
 }
 
 type painter struct {
@@ -34,6 +43,17 @@ func fillPath(worker *Worker, path raster.Path) []Scanline {
 	return p.Lines
 }
 
+// fillPolygon fills the polygon through the points given as x, y pairs.
+func fillPolygon(worker *Worker, xy ...float64) []Scanline {
+	var path raster.Path
+	path.Start(worker.point(xy[0], xy[1]))
+	for i := 2; i < len(xy); i += 2 {
+		path.Add1(worker.point(xy[i], xy[i+1]))
+	}
+	path.Add1(worker.point(xy[0], xy[1]))
+	return fillPath(worker, path)
+}
+
 func strokePath(worker *Worker, path raster.Path, width fixed.Int26_6, cr raster.Capper, jr raster.Joiner) []Scanline {
 	r := worker.Rasterizer
 	r.Clear()
@@ -2,6 +2,7 @@ package primitive
 
 import (
 	"fmt"
+	"image"
 	"math"
 
 	"github.com/fogleman/gg"
@@ -70,6 +71,11 @@ func (r *Rectangle) Mutate() {
 
 func (r *Rectangle) Rasterize() []Scanline {
 	x1, y1, x2, y2 := r.bounds()
+	if r.Worker.Antialias {
+		// the pixels x1 to x2, as drawn by Draw
+		fx1, fy1, fx2, fy2 := float64(x1), float64(y1), float64(x2+1), float64(y2+1)
+		return fillPolygon(r.Worker, fx1, fy1, fx2, fy1, fx2, fy2, fx1, fy2)
+	}
 	lines := r.Worker.Lines[:0]
 	for y := y1; y <= y2; y++ {
 		lines = append(lines, Scanline{y, x1, x2, 0xffff})
@@ -77,6 +83,11 @@ func (r *Rectangle) Rasterize() []Scanline {
 	return lines
 }
 
+func (r *Rectangle) Bounds() image.Rectangle {
+	x1, y1, x2, y2 := r.bounds()
+	return boundsOf(r.Worker, float64(x1), float64(y1), float64(x2), float64(y2))
+}
+
 type RotatedRectangle struct {
 	Worker *Worker
 	X, Y   int
@@ -159,6 +170,10 @@ func (r *RotatedRectangle) Rasterize() []Scanline {
 	x2, y2 := int(rx2)+r.X, int(ry2)+r.Y
 	x3, y3 := int(rx3)+r.X, int(ry3)+r.Y
 	x4, y4 := int(rx4)+r.X, int(ry4)+r.Y
+	if r.Worker.Antialias {
+		x, y := float64(r.X), float64(r.Y)
+		return fillPolygon(r.Worker, rx1+x, ry1+y, rx2+x, ry2+y, rx3+x, ry3+y, rx4+x, ry4+y)
+	}
 	miny := minInt(y1, minInt(y2, minInt(y3, y4)))
 	maxy := maxInt(y1, maxInt(y2, maxInt(y3, y4)))
 	n := maxy - miny + 1
@@ -196,3 +211,14 @@ func (r *RotatedRectangle) Rasterize() []Scanline {
 	}
 	return lines
 }
+
+func (r *RotatedRectangle) Bounds() image.Rectangle {
+	sx, sy := float64(r.Sx), float64(r.Sy)
+	angle := radians(float64(r.Angle))
+	// half the extent of the rotated rectangle on each axis
+	c, s := math.Abs(math.Cos(angle)), math.Abs(math.Sin(angle))
+	dx := (sx*c + sy*s) / 2
+	dy := (sx*s + sy*c) / 2
+	x, y := float64(r.X), float64(r.Y)
+	return boundsOf(r.Worker, x-dx, y-dy, x+dx, y+dy)
+}
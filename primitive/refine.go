@@ -0,0 +1,257 @@
+package primitive
+
+import (
+	"context"
+	"image"
+)
+
+// A refinement pass goes back over the shapes already in a model. Each one
+// is hill climbed in context: candidates are scored by recompositing only
+// the rows it touches, from the composite of the shapes below it (kept as
+// a running prefix during the pass) with the shapes above it drawn on top.
+// A shape is replaced only when that improves the score of the whole image.
+
+// layer is a shape's cached rasterization and bounding box.
+type layer struct {
+	lines          []Scanline
+	x1, y1, x2, y2 int
+}
+
+func newLayer(shape Shape) layer {
+	lines := append([]Scanline(nil), shape.Rasterize()...)
+	l := layer{lines: lines, x1: -1}
+	for _, line := range lines {
+		if l.x1 < 0 {
+			l.x1, l.y1, l.x2, l.y2 = line.X1, line.Y, line.X2, line.Y
+		}
+		l.x1 = minInt(l.x1, line.X1)
+		l.x2 = maxInt(l.x2, line.X2)
+		l.y1 = minInt(l.y1, line.Y)
+		l.y2 = maxInt(l.y2, line.Y)
+	}
+	return l
+}
+
+type refiner struct {
+	model      *Model
+	layers     []layer
+	background *image.RGBA
+	below      *image.RGBA // the background and the shapes below index
+	buffer     *image.RGBA // candidate composites, valid in region only
+	index      int
+
+	// the rows changed by the last candidate, one line per row
+	region       []Scanline
+	rowX1, rowX2 []int
+	clipped      []Scanline
+}
+
+// Refine runs one refinement pass over every shape of the model, hill
+// climbing each one's parameters and color in context for up to age moves
+// without improvement. It returns the number of shapes replaced. Shapes
+// keep their type, alpha, blend mode and the limits of their stage. If ctx
+// is cancelled the pass stops after the current shape, leaving the model
+// consistent.
+func (model *Model) Refine(ctx context.Context, age int) (int, error) {
+	if len(model.Shapes) == 0 {
+		return 0, ctx.Err()
+	}
+	r := newRefiner(model)
+	replaced := 0
+	var err error
+	for i := range model.Shapes {
+		if err = ctx.Err(); err != nil {
+			break
+		}
+		r.index = i
+		shape, color := model.Shapes[i], model.Colors[i]
+		lines := r.layers[i].lines
+		if !model.layerLimits[i].allow(lines) {
+			// scores would not be comparable; leave it alone
+			drawBlendLines(r.below, color, lines, model.Blends[i])
+			continue
+		}
+		current := &refineState{r, shape.Copy(), color, -1}
+		best := current
+		// the least squares color over what is below is a good start when
+		// the shapes above have changed a lot since this one was added
+		c := fitColor(model.Target, r.below, lines, color.A, model.Blends[i], model.antialias)
+		if s := (&refineState{r, shape.Copy(), c, -1}); s.Energy() < best.Energy() {
+			best = s
+		}
+		best = HillClimb(best, age).(*refineState)
+		if best.Energy() < current.Energy() {
+			// composite the winner again and commit its rows
+			best.Score = -1
+			model.Score = best.Energy()
+			copyLines(model.Current, r.buffer, r.region)
+			model.Shapes[i] = best.Shape
+			model.Colors[i] = best.Color
+			r.layers[i] = newLayer(best.Shape)
+			replaced++
+		}
+		drawBlendLines(r.below, model.Colors[i], r.layers[i].lines, model.Blends[i])
+	}
+
+	r.recomposite()
+	return replaced, err
+}
+
+func newRefiner(model *Model) *refiner {
+	size := model.Target.Bounds().Size()
+	background := uniformRGBA(model.Target.Bounds(), model.Background.NRGBA())
+	r := &refiner{
+		model:      model,
+		background: background,
+		below:      copyRGBA(background),
+		buffer:     image.NewRGBA(model.Target.Bounds()),
+		rowX1:      make([]int, size.Y),
+		rowX2:      make([]int, size.Y),
+	}
+	for y := range r.rowX1 {
+		r.rowX1[y] = -1
+	}
+	for _, shape := range model.Shapes {
+		r.layers = append(r.layers, newLayer(shape))
+	}
+	return r
+}
+
+// recomposite redraws the model from its shapes, recomputing the current
+// image, the score after each shape, the score and the output.
+func (r *refiner) recomposite() {
+	model := r.model
+	im := copyRGBA(r.background)
+	for i, l := range r.layers {
+		drawBlendLines(im, model.Colors[i], l.lines, model.Blends[i])
+		model.Scores[i] = differenceFull(model.Target, im)
+	}
+	model.Current = im
+	model.Score = differenceFull(model.Target, im)
+	model.Context = model.newContext()
+	for i := range model.Shapes {
+		model.drawShape(model.Context, i, model.Scale)
+	}
+}
+
+// energy returns the score of the whole image with the shape at index
+// replaced by the given shape and color.
+func (r *refiner) energy(shape Shape, color Color) float64 {
+	lines := shape.Rasterize()
+	if !r.model.layerLimits[r.index].allow(lines) {
+		return 1 + r.model.Score
+	}
+	return r.composite(lines, color)
+}
+
+// composite returns the score of the whole image with the lines of the
+// shape at index replaced by the given ones, none to leave it out. It
+// leaves the changed rows in r.region and their pixels in r.buffer.
+func (r *refiner) composite(lines []Scanline, color Color) float64 {
+	model := r.model
+	i := r.index
+
+	// the changed rows: the union of the old and the new shape
+	for _, y := range r.region {
+		r.rowX1[y.Y] = -1
+	}
+	r.region = r.region[:0]
+	rx1, rx2 := model.Target.Rect.Dx(), -1
+	add := func(line Scanline) {
+		y := line.Y
+		if r.rowX1[y] < 0 {
+			r.rowX1[y], r.rowX2[y] = line.X1, line.X2
+			r.region = append(r.region, Scanline{y, 0, 0, 0xffff})
+		} else {
+			r.rowX1[y] = minInt(r.rowX1[y], line.X1)
+			r.rowX2[y] = maxInt(r.rowX2[y], line.X2)
+		}
+		rx1 = minInt(rx1, line.X1)
+		rx2 = maxInt(rx2, line.X2)
+	}
+	for _, line := range r.layers[i].lines {
+		add(line)
+	}
+	for _, line := range lines {
+		add(line)
+	}
+	ry1, ry2 := model.Target.Rect.Dy(), -1
+	for k := range r.region {
+		line := &r.region[k]
+		line.X1, line.X2 = r.rowX1[line.Y], r.rowX2[line.Y]
+		ry1 = minInt(ry1, line.Y)
+		ry2 = maxInt(ry2, line.Y)
+	}
+
+	// below, the candidate, then everything above, clipped to the rows
+	copyLines(r.buffer, r.below, r.region)
+	drawBlendLines(r.buffer, color, lines, model.Blends[i])
+	for j := i + 1; j < len(r.layers); j++ {
+		l := &r.layers[j]
+		if l.x1 < 0 || l.x2 < rx1 || l.x1 > rx2 || l.y2 < ry1 || l.y1 > ry2 {
+			continue
+		}
+		clipped := r.clipped[:0]
+		for _, line := range l.lines {
+			x1 := r.rowX1[line.Y]
+			if x1 < 0 {
+				continue
+			}
+			line.X1 = maxInt(line.X1, x1)
+			line.X2 = minInt(line.X2, r.rowX2[line.Y])
+			if line.X1 <= line.X2 {
+				clipped = append(clipped, line)
+			}
+		}
+		drawBlendLines(r.buffer, model.Colors[j], clipped, model.Blends[j])
+		r.clipped = clipped
+	}
+	return differencePartial(model.Target, model.Current, r.buffer, model.Score, r.region)
+}
+
+// refineState is the Annealable used by Refine: moves either mutate the
+// shape or nudge one color channel.
+type refineState struct {
+	refiner *refiner
+	Shape   Shape
+	Color   Color
+	Score   float64
+}
+
+func (state *refineState) Energy() float64 {
+	if state.Score < 0 {
+		state.Score = state.refiner.energy(state.Shape, state.Color)
+	}
+	return state.Score
+}
+
+func (state *refineState) DoMove() interface{} {
+	rnd := state.refiner.model.Workers[0].Rnd
+	old := state.Copy()
+	if rnd.Intn(4) == 0 {
+		d := rnd.Intn(17) - 8
+		switch rnd.Intn(3) {
+		case 0:
+			state.Color.R = clampInt(state.Color.R+d, 0, 255)
+		case 1:
+			state.Color.G = clampInt(state.Color.G+d, 0, 255)
+		case 2:
+			state.Color.B = clampInt(state.Color.B+d, 0, 255)
+		}
+	} else {
+		state.Shape.Mutate()
+	}
+	state.Score = -1
+	return old
+}
+
+func (state *refineState) UndoMove(undo interface{}) {
+	old := undo.(*refineState)
+	state.Shape = old.Shape
+	state.Color = old.Color
+	state.Score = old.Score
+}
+
+func (state *refineState) Copy() Annealable {
+	return &refineState{state.refiner, state.Shape.Copy(), state.Color, state.Score}
+}
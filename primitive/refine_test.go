@@ -0,0 +1,86 @@
+package primitive
+
+import (
+	"context"
+	"image"
+	"math"
+	"testing"
+)
+
+// testTarget returns the example image downscaled for quick runs.
+func testTarget(t testing.TB, size int) image.Image {
+	im, err := LoadImage("../examples/monalisa.png")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return Downscale(im, size, FilterBilinear)
+}
+
+var testSearch = Search{Candidates: 100, Age: 20, Restarts: 4}
+
+// testRuns are run options that each take a different path through the
+// search and the drawing code.
+var testRuns = []struct {
+	name    string
+	options Options
+}{
+	{"triangles", Options{Stages: []Stage{{Count: 12, Mode: ShapeTypeTriangle, Alpha: 128}}}},
+	{"auto alpha", Options{Stages: []Stage{{Count: 12, Mode: ShapeTypeEllipse, Alpha: AlphaAuto}}}},
+	{"blend", Options{Stages: []Stage{
+		{Count: 6, Mode: ShapeTypeRectangle, Alpha: 160},
+		{Count: 6, Modes: []ShapeType{ShapeTypeRotatedEllipse, ShapeTypeQuadratic}, Alpha: 128, Blend: BlendMultiply},
+	}}},
+	{"repeat", Options{Stages: []Stage{{Count: 4, Mode: ShapeTypePolygon, Alpha: 128, Repeat: 2}}}},
+	{"antialias", Options{Antialias: true, Stages: []Stage{{Count: 12, Mode: ShapeTypeRotatedRectangle, Alpha: 128}}}},
+	{"transparent", Options{Background: &Color{}, Stages: []Stage{{Count: 12, Mode: ShapeTypeTriangle, Alpha: AlphaAuto}}}},
+	{"pyramid", Options{Pyramid: 1, Stages: []Stage{{Count: 12, Mode: ShapeTypeEllipse, Alpha: 128}}}},
+	{"tiles", Options{Tile: 32, Stages: []Stage{{Count: 12, Mode: ShapeTypeTriangle, Alpha: 128}}}},
+}
+
+// testRun runs options on a small target with a fixed seed and a small
+// search.
+func testRun(t testing.TB, target image.Image, options Options, workers int) *Model {
+	options.Workers = workers
+	options.Seed = 1
+	options.OutputSize = 64
+	options.Search = testSearch
+	model, err := Run(context.Background(), target, options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return model
+}
+
+// TestRefinePruneScore checks that refinement and both prune strategies
+// leave model.Score equal to the score of model.Current.
+func TestRefinePruneScore(t *testing.T) {
+	target := testTarget(t, 48)
+	steps := []struct {
+		name string
+		step func(model *Model) error
+	}{
+		{"refine", func(model *Model) error {
+			_, err := model.Refine(context.Background(), 20)
+			return err
+		}},
+		{"prune greedy", func(model *Model) error {
+			_, err := model.Prune(context.Background(), PruneOptions{Strategy: PruneGreedy, Count: 8})
+			return err
+		}},
+		{"prune iterative", func(model *Model) error {
+			_, err := model.Prune(context.Background(), PruneOptions{Strategy: PruneIterative, Count: 4})
+			return err
+		}},
+	}
+	for _, run := range testRuns {
+		model := testRun(t, target, run.options, 1)
+		for _, step := range steps {
+			if err := step.step(model); err != nil {
+				t.Fatalf("%s, %s: %v", run.name, step.name, err)
+			}
+			if want := differenceFull(model.Target, model.Current); math.Abs(model.Score-want) > 1e-9 {
+				t.Errorf("%s, %s: score %v, differenceFull %v", run.name, step.name, model.Score, want)
+			}
+		}
+	}
+}
@@ -0,0 +1,228 @@
+package primitive
+
+import (
+	"fmt"
+	"image"
+	"image/draw"
+	"math"
+	"sync"
+
+	"github.com/nfnt/resize"
+)
+
+// Filter is the resampling filter used to shrink the input to the working
+// size. FilterBilinear matches earlier releases; the others work in linear
+// light, so fine detail averages to the right brightness instead of
+// aliasing or darkening.
+type Filter int
+
+const (
+	FilterBilinear Filter = iota
+	FilterArea
+	FilterLanczos
+	FilterMitchell
+)
+
+var filterNames = []string{"bilinear", "area", "lanczos", "mitchell"}
+
+func (f Filter) String() string {
+	if f < 0 || int(f) >= len(filterNames) {
+		return fmt.Sprintf("Filter(%d)", int(f))
+	}
+	return filterNames[f]
+}
+
+// ParseFilter accepts a filter name, with "" meaning bilinear.
+func ParseFilter(s string) (Filter, error) {
+	if s == "" {
+		return FilterBilinear, nil
+	}
+	for i, name := range filterNames {
+		if s == name {
+			return Filter(i), nil
+		}
+	}
+	return 0, invalid("filter", s, "unknown resampling filter", suggest(s, filterNames))
+}
+
+// thumbnailSize returns the size of im shrunk to fit in size x size, with
+// the same rounding as resize.Thumbnail.
+func thumbnailSize(w, h, size int) (int, int) {
+	if w <= size && h <= size {
+		return w, h
+	}
+	if w > size {
+		w, h = size, maxInt(h*size/w, 1)
+	}
+	if h > size {
+		w, h = maxInt(w*size/h, 1), size
+	}
+	return w, h
+}
+
+// Downscale shrinks im to fit in size x size, keeping its aspect ratio.
+// Images that already fit are returned as they are.
+func Downscale(im image.Image, size int, filter Filter) image.Image {
+	b := im.Bounds()
+	w, h := thumbnailSize(b.Dx(), b.Dy(), size)
+	if w == b.Dx() && h == b.Dy() {
+		return im
+	}
+	return resample(im, w, h, filter)
+}
+
+// resample resizes im to exactly w x h with the given filter.
+func resample(im image.Image, w, h int, filter Filter) image.Image {
+	switch filter {
+	case FilterArea:
+		return fromLinear(areaResize(toLinear(im), w, h))
+	case FilterLanczos:
+		return fromLinear(resize.Resize(uint(w), uint(h), toLinear(im), resize.Lanczos3).(*image.RGBA64))
+	case FilterMitchell:
+		return fromLinear(resize.Resize(uint(w), uint(h), toLinear(im), resize.MitchellNetravali).(*image.RGBA64))
+	}
+	return resize.Resize(uint(w), uint(h), im, resize.Bilinear)
+}
+
+var (
+	gammaOnce    sync.Once
+	srgbToLinear [256]uint16
+	linearToSRGB [65536]uint8
+)
+
+func gammaTables() {
+	gammaOnce.Do(func() {
+		for i := range srgbToLinear {
+			v := float64(i) / 255
+			if v <= 0.04045 {
+				v /= 12.92
+			} else {
+				v = math.Pow((v+0.055)/1.055, 2.4)
+			}
+			srgbToLinear[i] = uint16(v*65535 + 0.5)
+		}
+		for i := range linearToSRGB {
+			v := float64(i) / 65535
+			if v <= 0.0031308 {
+				v *= 12.92
+			} else {
+				v = 1.055*math.Pow(v, 1/2.4) - 0.055
+			}
+			linearToSRGB[i] = uint8(clamp(v, 0, 1)*255 + 0.5)
+		}
+	})
+}
+
+// toLinear converts im to premultiplied 16 bit linear light.
+func toLinear(im image.Image) *image.RGBA64 {
+	gammaTables()
+	b := im.Bounds()
+	src := image.NewNRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+	draw.Draw(src, src.Rect, im, b.Min, draw.Src)
+	dst := image.NewRGBA64(src.Rect)
+	for i, j := 0, 0; i < len(src.Pix); i, j = i+4, j+8 {
+		a := uint32(src.Pix[i+3]) * 0x101
+		for k := 0; k < 3; k++ {
+			v := uint32(srgbToLinear[src.Pix[i+k]]) * a / 0xffff
+			dst.Pix[j+2*k], dst.Pix[j+2*k+1] = uint8(v>>8), uint8(v)
+		}
+		dst.Pix[j+6], dst.Pix[j+7] = uint8(a>>8), uint8(a)
+	}
+	return dst
+}
+
+// fromLinear converts a premultiplied linear light image back to sRGB.
+func fromLinear(im *image.RGBA64) *image.NRGBA {
+	gammaTables()
+	dst := image.NewNRGBA(image.Rect(0, 0, im.Rect.Dx(), im.Rect.Dy()))
+	for i, j := 0, 0; i < len(dst.Pix); i, j = i+4, j+8 {
+		a := uint32(im.Pix[j+6])<<8 | uint32(im.Pix[j+7])
+		if a == 0 {
+			continue
+		}
+		for k := 0; k < 3; k++ {
+			v := uint32(im.Pix[j+2*k])<<8 | uint32(im.Pix[j+2*k+1])
+			dst.Pix[i+k] = linearToSRGB[minInt(int(v*0xffff/a), 0xffff)]
+		}
+		dst.Pix[i+3] = uint8((a + 128) / 257)
+	}
+	return dst
+}
+
+// areaResize shrinks im to w x h, averaging each output pixel over the
+// part of the input it covers.
+func areaResize(im *image.RGBA64, w, h int) *image.RGBA64 {
+	sw, sh := im.Rect.Dx(), im.Rect.Dy()
+	dst := image.NewRGBA64(image.Rect(0, 0, w, h))
+	fx := float64(sw) / float64(w)
+	fy := float64(sh) / float64(h)
+	for y := 0; y < h; y++ {
+		y0, y1 := float64(y)*fy, float64(y+1)*fy
+		for x := 0; x < w; x++ {
+			x0, x1 := float64(x)*fx, float64(x+1)*fx
+			var sum [4]float64
+			var total float64
+			for sy := int(y0); sy < sh && float64(sy) < y1; sy++ {
+				wy := math.Min(y1, float64(sy+1)) - math.Max(y0, float64(sy))
+				for sx := int(x0); sx < sw && float64(sx) < x1; sx++ {
+					wx := math.Min(x1, float64(sx+1)) - math.Max(x0, float64(sx))
+					weight := wx * wy
+					p := im.Pix[im.PixOffset(sx, sy):]
+					for k := 0; k < 4; k++ {
+						sum[k] += weight * float64(uint32(p[2*k])<<8|uint32(p[2*k+1]))
+					}
+					total += weight
+				}
+			}
+			p := dst.Pix[dst.PixOffset(x, y):]
+			for k := 0; k < 4; k++ {
+				v := uint16(clamp(sum[k]/total+0.5, 0, 0xffff))
+				p[2*k], p[2*k+1] = uint8(v>>8), uint8(v)
+			}
+		}
+	}
+	return dst
+}
+
+// AutoInputSize picks a working size for a run of the given number of
+// shapes: enough pixels for each shape to be placed with some precision,
+// more for detailed targets and less for flat ones, between 64 and 512
+// and never larger than the target.
+func AutoInputSize(target image.Image, shapes int) int {
+	b := target.Bounds()
+	longest := maxInt(b.Dx(), b.Dy())
+	base := math.Sqrt(float64(shapes) * 200)
+	size := int(base*(0.75+5*imageDetail(target))/16+0.5) * 16
+	return minInt(clampInt(size, 64, 512), longest)
+}
+
+// imageDetail measures the detail in an image as the mean absolute
+// difference of neighboring luminance values, from 0 for flat images to
+// around 0.1 for busy photos, on a copy no larger than 256 pixels.
+func imageDetail(im image.Image) float64 {
+	small := imageToRGBA(resize.Thumbnail(256, 256, im, resize.Bilinear))
+	w, h := small.Rect.Dx(), small.Rect.Dy()
+	luma := func(x, y int) float64 {
+		p := small.Pix[small.PixOffset(x, y):]
+		return (0.299*float64(p[0]) + 0.587*float64(p[1]) + 0.114*float64(p[2])) / 255
+	}
+	var total float64
+	var count int
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			v := luma(x, y)
+			if x+1 < w {
+				total += math.Abs(luma(x+1, y) - v)
+				count++
+			}
+			if y+1 < h {
+				total += math.Abs(luma(x, y+1) - v)
+				count++
+			}
+		}
+	}
+	if count == 0 {
+		return 0
+	}
+	return total / float64(count)
+}
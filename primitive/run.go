@@ -0,0 +1,390 @@
+package primitive
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/nfnt/resize"
+)
+
+// Search is the per-step search budget: each step scores Candidates random
+// shapes, hill climbs the best one until Age moves in a row fail to improve
+// it, and does this Restarts times. The random candidates and the climbs
+// are shared out between the workers as they become free.
+type Search struct {
+	Candidates int `json:"candidates,omitempty" yaml:"candidates"`
+	Age        int `json:"age,omitempty" yaml:"age"`
+	Restarts   int `json:"restarts,omitempty" yaml:"restarts"`
+}
+
+var DefaultSearch = Search{Candidates: 1000, Age: 100, Restarts: 16}
+
+// Stage adds Count shapes of the given type. Alpha 0 lets the algorithm
+// choose alpha for each shape by hill climbing, AlphaAuto fits it with each
+// shape's color, and Repeat adds extra shapes per step with a reduced
+// search.
+//
+// The remaining fields are optional. Modes picks each shape's type from a
+// list instead of Mode. Search overrides the run's search budget for this
+// stage. MinSize and MaxSize bound the longest side of each shape's
+// bounding box in target pixels. Shapes must lie mostly in the light parts
+// of Mask, which is stretched over the target. Blend sets how shapes
+// combine with what is below them. MinAlpha and MaxAlpha bound the alpha
+// chosen with AlphaAuto; 0 leaves it unbounded.
+type Stage struct {
+	Count  int
+	Mode   ShapeType
+	Alpha  int
+	Repeat int
+
+	Modes   []ShapeType
+	Search  Search
+	MinSize int
+	MaxSize int
+	Mask    image.Image
+	Blend   BlendMode
+
+	MinAlpha int
+	MaxAlpha int
+}
+
+// AlphaAuto as a stage's alpha fits each shape's alpha together with its
+// color, by least squares over the pixels it covers.
+const AlphaAuto = -1
+
+// validate checks stage number index, and its min size against the target
+// size when that is known, reporting every problem found.
+func (stage *Stage) validate(index, w, h int) error {
+	var errs []error
+	fail := func(field string, value interface{}, reason, hint string) {
+		err := invalid(field, value, reason, hint)
+		err.Stage = index
+		errs = append(errs, err)
+	}
+	if stage.Count < 1 {
+		fail("count", stage.Count, "must be > 0", "")
+	}
+	for _, t := range append([]ShapeType{stage.Mode}, stage.Modes...) {
+		if t < ShapeTypeAny || t > ShapeTypePolygon {
+			fail("shape", int(t), "unknown shape type", shapeTypeHint)
+		}
+	}
+	if stage.Alpha != AlphaAuto && (stage.Alpha < 0 || stage.Alpha > 255) {
+		fail("alpha", stage.Alpha, "must be between 0 and 255", "use 0 or auto to choose alpha for each shape")
+	}
+	if stage.MinAlpha < 0 || stage.MinAlpha > 255 {
+		fail("min_alpha", stage.MinAlpha, "must be between 0 and 255", "")
+	}
+	if stage.MaxAlpha < 0 || stage.MaxAlpha > 255 {
+		fail("max_alpha", stage.MaxAlpha, "must be between 0 and 255", "")
+	}
+	if stage.MaxAlpha > 0 && stage.MinAlpha > stage.MaxAlpha {
+		fail("min_alpha", stage.MinAlpha, fmt.Sprintf("is larger than max_alpha %d", stage.MaxAlpha), "")
+	}
+	if (stage.MinAlpha > 0 || stage.MaxAlpha > 0) && stage.Alpha != AlphaAuto {
+		fail("min_alpha", nil, "only applies when alpha is auto", "")
+	}
+	if stage.Repeat < 0 {
+		fail("repeat", stage.Repeat, "must be >= 0", "")
+	}
+	if s := stage.Search; s.Candidates < 0 || s.Age < 0 || s.Restarts < 0 {
+		fail("search", nil, "candidates, age and restarts must be >= 0", "use 0 for the run's budget")
+	}
+	if stage.MinSize < 0 {
+		fail("min_size", stage.MinSize, "must be >= 0", "")
+	}
+	if stage.MaxSize < 0 {
+		fail("max_size", stage.MaxSize, "must be >= 0", "")
+	}
+	if stage.MaxSize > 0 && stage.MinSize > stage.MaxSize {
+		fail("min_size", stage.MinSize, fmt.Sprintf("is larger than max_size %d", stage.MaxSize), "")
+	}
+	if w > 0 && stage.MinSize > maxInt(w, h) {
+		fail("min_size", stage.MinSize, fmt.Sprintf("is larger than the %dx%d target", w, h),
+			"sizes are in working image pixels, after resizing the input")
+	}
+	if stage.Blend < BlendNormal || stage.Blend > BlendScreen {
+		fail("blend", int(stage.Blend), "unknown blend mode", "use one of "+strings.Join(blendModeNames, ", "))
+	}
+	return errors.Join(errs...)
+}
+
+// limits converts the stage's constraints for the workers, scaling the
+// mask to the target size.
+func (stage *Stage) limits(w, h int) (limits, error) {
+	l := limits{MinSize: stage.MinSize, MaxSize: stage.MaxSize, Blend: stage.Blend,
+		MinAlpha: stage.MinAlpha, MaxAlpha: stage.MaxAlpha}
+	for _, t := range stage.Modes {
+		if t == ShapeTypeAny {
+			// combo already covers every type
+			l.Types = nil
+			break
+		}
+		l.Types = append(l.Types, t)
+	}
+	if stage.Mask != nil {
+		mask := resize.Resize(uint(w), uint(h), stage.Mask, resize.Bilinear)
+		gray := image.NewGray(image.Rect(0, 0, w, h))
+		b := mask.Bounds()
+		open := false
+		for y := 0; y < h; y++ {
+			for x := 0; x < w; x++ {
+				r, g, bl, a := mask.At(b.Min.X+x, b.Min.Y+y).RGBA()
+				// luminance, with transparent pixels counting as black
+				v := (299*r + 587*g + 114*bl) / 1000 * a / 0xffff >> 8
+				gray.Pix[y*gray.Stride+x] = uint8(v)
+				open = open || v >= 128
+			}
+		}
+		if !open {
+			return l, errors.New("mask has no light pixels")
+		}
+		l.Mask = gray
+	}
+	return l, nil
+}
+
+// Options configures Run. Zero values select the defaults: the target is
+// not resized, the output size is 1024, one worker per CPU, a time based
+// seed, the default search and the average target color as background.
+// InputSize shrinks the target to fit in a square of that size using
+// Filter, or to a size picked by AutoInputSize when it is InputSizeAuto.
+// Pyramid is the number of coarse levels searched for large shapes, see
+// Model.SetPyramid. Refine is the number of Model.Refine passes run after
+// the last stage, and RefineEvery, if set, runs one more pass each time
+// that many shapes have been added. Tile, if set, searches the working
+// image in overlapping tiles of that size, see Model.SetTiles; it cannot be
+// combined with Pyramid or with stages that Repeat. Antialias scores shapes
+// with the antialiased edges they are drawn with, see Model.SetAntialias.
+// Slots, if set, is shared between runs to cap how many of their workers
+// search at once, see Model.SetSlots.
+type Options struct {
+	Stages      []Stage
+	InputSize   int
+	Filter      Filter
+	Pyramid     int
+	Refine      int
+	RefineEvery int
+	Tile        int
+	Antialias   bool
+	OutputSize  int
+	Workers     int
+	Seed        int64
+	Background  *Color
+	Search      Search
+	Slots       chan struct{}
+
+	// OnShape, if set, is called synchronously after each accepted shape.
+	OnShape func(Event)
+}
+
+// InputSizeAuto lets Run pick the working size from the number of shapes
+// and the detail in the target.
+const InputSizeAuto = -1
+
+// Event describes an accepted shape. Model is the model being built; it
+// must not be modified or retained by the callback.
+type Event struct {
+	Model     *Model
+	Frame     int
+	Stage     int
+	Shape     Shape
+	Color     Color
+	Score     float64
+	Evaluated int
+	Rejected  int // of Evaluated, rejected by their bounds
+	Duration  time.Duration
+	Elapsed   time.Duration
+}
+
+func (options *Options) defaults() {
+	if options.OutputSize <= 0 {
+		options.OutputSize = 1024
+	}
+	if options.Workers <= 0 {
+		options.Workers = runtime.NumCPU()
+	}
+	if options.Seed == 0 {
+		options.Seed = time.Now().UTC().UnixNano()
+	}
+	if options.Search.Candidates <= 0 {
+		options.Search.Candidates = DefaultSearch.Candidates
+	}
+	if options.Search.Age <= 0 {
+		options.Search.Age = DefaultSearch.Age
+	}
+	if options.Search.Restarts <= 0 {
+		options.Search.Restarts = DefaultSearch.Restarts
+	}
+}
+
+// Run reproduces the target with the configured stages of shapes. When ctx
+// is cancelled, Run stops the workers in the middle of the current step and
+// returns the model built so far along with ctx.Err().
+func Run(ctx context.Context, target image.Image, options Options) (*Model, error) {
+	if err := options.Validate(); err != nil {
+		return nil, err
+	}
+	options.defaults()
+
+	inputSize := options.InputSize
+	if inputSize == InputSizeAuto {
+		shapes := 0
+		for _, stage := range options.Stages {
+			shapes += stage.Count * (1 + stage.Repeat)
+		}
+		inputSize = AutoInputSize(target, shapes)
+	}
+	if inputSize > 0 {
+		target = Downscale(target, inputSize, options.Filter)
+	}
+	w, h := target.Bounds().Dx(), target.Bounds().Dy()
+	var stageLimits []limits
+	for i := range options.Stages {
+		stage := &options.Stages[i]
+		if err := stage.validate(i+1, w, h); err != nil {
+			return nil, err
+		}
+		l, err := stage.limits(w, h)
+		if err != nil {
+			return nil, &ValidationError{i + 1, "mask", nil, err.Error(), "use white or light gray where shapes may go"}
+		}
+		stageLimits = append(stageLimits, l)
+	}
+	var bg Color
+	if options.Background == nil {
+		bg = MakeColor(AverageImageColor(target))
+	} else {
+		bg = *options.Background
+	}
+
+	workers := options.Workers
+	if options.Tile > 0 {
+		workers = 1 // the tiles have their own
+	}
+	model := NewModel(target, bg, options.OutputSize, workers)
+	for i, worker := range model.Workers {
+		worker.Rnd.Seed(options.Seed + int64(i))
+	}
+	model.SetPyramid(options.Pyramid)
+	model.SetTiles(options.Tile, options.Workers)
+	model.SetAntialias(options.Antialias)
+	model.SetSlots(options.Slots)
+	model.Meta = Metadata{
+		Seed:       options.Seed,
+		InputSize:  inputSize,
+		AutoSize:   options.InputSize == InputSizeAuto,
+		Filter:     options.Filter,
+		Pyramid:    len(model.pyramid),
+		Tile:       options.Tile,
+		Antialias:  options.Antialias,
+		OutputSize: options.OutputSize,
+		Stages:     options.Stages,
+	}
+
+	start := time.Now()
+	refine := func(age int) error {
+		n, err := model.Refine(ctx, age)
+		model.Meta.Refined += n
+		model.Meta.Elapsed = time.Since(start)
+		return err
+	}
+	passes := 0
+	for j, stage := range options.Stages {
+		search := options.Search
+		if stage.Search.Candidates > 0 {
+			search.Candidates = stage.Search.Candidates
+		}
+		if stage.Search.Age > 0 {
+			search.Age = stage.Search.Age
+		}
+		if stage.Search.Restarts > 0 {
+			search.Restarts = stage.Search.Restarts
+		}
+		model.startStage(stageLimits[j])
+		model.Meta.StageEnds = append(model.Meta.StageEnds, len(model.Shapes))
+		idle := 0 // tiled rounds in a row that added nothing
+		for i := 0; i < stage.Count; {
+			if err := ctx.Err(); err != nil {
+				return model, err
+			}
+			t := time.Now()
+			added := func(n, rejected int) {
+				if options.OnShape == nil {
+					return
+				}
+				k := len(model.Shapes) - 1
+				model.Meta.StageEnds[j] = k + 1
+				options.OnShape(Event{
+					model, k + 1, j, model.Shapes[k], model.Colors[k], model.Score,
+					n, rejected, time.Since(t), time.Since(start)})
+				t = time.Now()
+			}
+			var n, r int
+			var err error
+			if model.tiles == nil {
+				n, r, err = model.step(ctx, stage, search, stageLimits[j], added)
+				i++
+			} else {
+				var k int
+				k, n, r, err = model.stepTiles(ctx, stage, search, stageLimits[j], stage.Count-i, added)
+				i += k
+				idle++
+				if k > 0 {
+					idle = 0
+				}
+				if idle == 2 && err == nil {
+					// nothing improved on either grid, so nothing will
+					warn("no tile can improve; ending stage early",
+						"stage", j+1, "shapes", i, "count", stage.Count)
+					i = stage.Count
+				}
+			}
+			model.Meta.Evaluated += n
+			model.Meta.Rejected += r
+			model.Meta.Elapsed = time.Since(start)
+			if err != nil {
+				return model, err
+			}
+			if every := options.RefineEvery; every > 0 && len(model.Shapes)/every > passes {
+				passes = len(model.Shapes) / every
+				if err := refine(search.Age); err != nil {
+					return model, err
+				}
+			}
+		}
+	}
+	for i := 0; i < options.Refine; i++ {
+		if err := refine(options.Search.Age); err != nil {
+			return model, err
+		}
+	}
+	return model, nil
+}
+
+// MarshalJSON encodes the event as a single line progress record.
+func (e Event) MarshalJSON() ([]byte, error) {
+	t, params := ShapeData(e.Shape)
+	c := e.Color
+	return json.Marshal(struct {
+		Frame     int       `json:"frame"`
+		Stage     int       `json:"stage"`
+		Elapsed   float64   `json:"elapsed"`
+		Duration  float64   `json:"duration"`
+		Score     float64   `json:"score"`
+		Evaluated int       `json:"evaluated"`
+		Rejected  int       `json:"rejected"`
+		Shape     string    `json:"shape"`
+		Color     [4]int    `json:"color"`
+		Params    []float64 `json:"params"`
+	}{
+		e.Frame, e.Stage + 1, e.Elapsed.Seconds(), e.Duration.Seconds(), e.Score, e.Evaluated,
+		e.Rejected, t.String(), [4]int{c.R, c.G, c.B, c.A}, params,
+	})
+}
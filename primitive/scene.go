@@ -0,0 +1,281 @@
+package primitive
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image"
+	"io"
+	"os"
+	"time"
+)
+
+// Metadata describes how a model was built. Run fills in everything but
+// Input, which callers may set to the path of the target image.
+type Metadata struct {
+	Input      string
+	Seed       int64
+	InputSize  int // the working size, after resolving InputSizeAuto
+	AutoSize   bool
+	Filter     Filter
+	Pyramid    int // coarse levels actually used
+	Tile       int
+	Antialias  bool
+	OutputSize int
+	Stages     []Stage
+	StageEnds  []int // the number of shapes at the end of each stage run
+	Elapsed    time.Duration
+	Evaluated  int
+	Rejected   int // of Evaluated, rejected by their bounds
+	Refined    int // shapes replaced by refinement passes
+	Pruned     int // shapes removed by Prune
+}
+
+// Scene is the JSON form of a model: its shapes and colors along with the
+// run metadata. It is enough to redraw the model without the target image.
+// Background is RGB, with a fourth alpha value when it is not opaque.
+type Scene struct {
+	Version    int          `json:"version"`
+	Input      string       `json:"input,omitempty"`
+	Width      int          `json:"width"`
+	Height     int          `json:"height"`
+	Scale      float64      `json:"scale"`
+	Background []int        `json:"background"`
+	Score      float64      `json:"score"`
+	Seed       int64        `json:"seed,omitempty"`
+	InputSize  int          `json:"input_size,omitempty"`
+	AutoSize   bool         `json:"auto_size,omitempty"`
+	Filter     string       `json:"filter,omitempty"`
+	Pyramid    int          `json:"pyramid,omitempty"`
+	Tile       int          `json:"tile,omitempty"`
+	Antialias  bool         `json:"antialias,omitempty"`
+	OutputSize int          `json:"output_size,omitempty"`
+	Elapsed    float64      `json:"elapsed"`
+	Evaluated  int          `json:"evaluated"`
+	Rejected   int          `json:"rejected,omitempty"`
+	Refined    int          `json:"refined,omitempty"`
+	Pruned     int          `json:"pruned,omitempty"`
+	Stages     []SceneStage `json:"stages,omitempty"`
+	Shapes     []SceneShape `json:"shapes"`
+}
+
+type SceneStage struct {
+	Count    int        `json:"count"`
+	Mode     string     `json:"mode"`
+	Alpha    AlphaValue `json:"alpha"`
+	Repeat   int        `json:"repeat"`
+	Modes    []string   `json:"modes,omitempty"`
+	Search   *Search    `json:"search,omitempty"`
+	MinSize  int        `json:"min_size,omitempty"`
+	MaxSize  int        `json:"max_size,omitempty"`
+	MinAlpha int        `json:"min_alpha,omitempty"`
+	MaxAlpha int        `json:"max_alpha,omitempty"`
+	Masked   bool       `json:"masked,omitempty"`
+	Blend    string     `json:"blend,omitempty"`
+}
+
+type SceneShape struct {
+	Type   string    `json:"type"`
+	Color  [4]int    `json:"color"`
+	Params []float64 `json:"params"`
+	Score  float64   `json:"score"`
+	Blend  string    `json:"blend,omitempty"`
+}
+
+const sceneVersion = 1
+
+// Scene returns the model's shapes and metadata. Width and Height are the
+// size of the (resized) target that the shape parameters refer to.
+func (model *Model) Scene() *Scene {
+	size := model.Target.Bounds().Size()
+	bg := model.Background
+	meta := model.Meta
+	scene := &Scene{
+		Version:    sceneVersion,
+		Input:      meta.Input,
+		Width:      size.X,
+		Height:     size.Y,
+		Scale:      model.Scale,
+		Background: []int{bg.R, bg.G, bg.B},
+		Score:      model.Score,
+		Seed:       meta.Seed,
+		InputSize:  meta.InputSize,
+		AutoSize:   meta.AutoSize,
+		Filter:     meta.Filter.String(),
+		Pyramid:    meta.Pyramid,
+		Tile:       meta.Tile,
+		Antialias:  meta.Antialias,
+		OutputSize: meta.OutputSize,
+		Elapsed:    meta.Elapsed.Seconds(),
+		Evaluated:  meta.Evaluated,
+		Rejected:   meta.Rejected,
+		Refined:    meta.Refined,
+		Pruned:     meta.Pruned,
+		Shapes:     []SceneShape{},
+	}
+	if bg.A != 255 {
+		scene.Background = append(scene.Background, bg.A)
+	}
+	for _, stage := range meta.Stages {
+		s := SceneStage{
+			Count:    stage.Count,
+			Mode:     stage.Mode.String(),
+			Alpha:    AlphaValue(stage.Alpha),
+			Repeat:   stage.Repeat,
+			MinSize:  stage.MinSize,
+			MaxSize:  stage.MaxSize,
+			MinAlpha: stage.MinAlpha,
+			MaxAlpha: stage.MaxAlpha,
+			Masked:   stage.Mask != nil,
+		}
+		for _, t := range stage.Modes {
+			s.Modes = append(s.Modes, t.String())
+		}
+		if stage.Search != (Search{}) {
+			search := stage.Search
+			s.Search = &search
+		}
+		if stage.Blend != BlendNormal {
+			s.Blend = stage.Blend.String()
+		}
+		scene.Stages = append(scene.Stages, s)
+	}
+	for i, shape := range model.Shapes {
+		t, params := ShapeData(shape)
+		c := model.Colors[i]
+		s := SceneShape{Type: t.String(), Color: [4]int{c.R, c.G, c.B, c.A}, Params: params, Score: model.Scores[i]}
+		if blend := model.Blends[i]; blend != BlendNormal {
+			s.Blend = blend.String()
+		}
+		scene.Shapes = append(scene.Shapes, s)
+	}
+	return scene
+}
+
+func (model *Model) WriteScene(w io.Writer) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(model.Scene())
+}
+
+func ReadScene(r io.Reader) (*Scene, error) {
+	var scene Scene
+	if err := json.NewDecoder(r).Decode(&scene); err != nil {
+		return nil, err
+	}
+	if scene.Version < 1 || scene.Version > sceneVersion {
+		return nil, fmt.Errorf("unsupported scene version: %d", scene.Version)
+	}
+	if scene.Width < 1 || scene.Height < 1 {
+		return nil, errors.New("scene has no size")
+	}
+	return &scene, nil
+}
+
+func LoadScene(path string) (*Scene, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return ReadScene(file)
+}
+
+// Model rebuilds the model described by the scene. The target is resized
+// to the scene's size; if it is nil a target filled with the background
+// color is used, which is enough for drawing but not for scoring.
+func (scene *Scene) Model(target image.Image, size, workers int) (*Model, error) {
+	bg := Color{A: 255}
+	switch b := scene.Background; len(b) {
+	case 3, 4:
+		bg.R, bg.G, bg.B = b[0], b[1], b[2]
+		if len(b) == 4 {
+			bg.A = b[3]
+		}
+	default:
+		return nil, fmt.Errorf("background must have 3 or 4 values, got %d", len(b))
+	}
+	filter, err := ParseFilter(scene.Filter)
+	if err != nil {
+		return nil, err
+	}
+	if target == nil {
+		target = uniformRGBA(image.Rect(0, 0, scene.Width, scene.Height), bg.NRGBA())
+	} else if b := target.Bounds().Size(); b.X != scene.Width || b.Y != scene.Height {
+		target = resample(target, scene.Width, scene.Height, filter)
+	}
+	if size <= 0 {
+		size = int(float64(maxInt(scene.Width, scene.Height))*scene.Scale + 0.5)
+	}
+	if workers <= 0 {
+		workers = 1
+	}
+	model := NewModel(target, bg, size, workers)
+	model.Meta = Metadata{
+		Input:      scene.Input,
+		Seed:       scene.Seed,
+		InputSize:  scene.InputSize,
+		AutoSize:   scene.AutoSize,
+		Filter:     filter,
+		Pyramid:    scene.Pyramid,
+		Tile:       scene.Tile,
+		Antialias:  scene.Antialias,
+		OutputSize: scene.OutputSize,
+		Elapsed:    time.Duration(scene.Elapsed * float64(time.Second)),
+		Evaluated:  scene.Evaluated,
+		Rejected:   scene.Rejected,
+		Refined:    scene.Refined,
+		Pruned:     scene.Pruned,
+	}
+	for i, s := range scene.Stages {
+		stage, err := s.stage()
+		if err != nil {
+			return nil, fmt.Errorf("stage %d: %v", i+1, err)
+		}
+		model.Meta.Stages = append(model.Meta.Stages, stage)
+	}
+	model.SetAntialias(scene.Antialias)
+	for i, s := range scene.Shapes {
+		t, err := ParseShapeType(s.Type)
+		if err != nil {
+			return nil, fmt.Errorf("shape %d: %v", i+1, err)
+		}
+		shape, err := NewShape(model.Workers[0], t, s.Params)
+		if err != nil {
+			return nil, fmt.Errorf("shape %d: %v", i+1, err)
+		}
+		blend, err := ParseBlendMode(s.Blend)
+		if err != nil {
+			return nil, fmt.Errorf("shape %d: %v", i+1, err)
+		}
+		c := s.Color
+		model.AddColor(shape, Color{c[0], c[1], c[2], c[3]}, blend)
+	}
+	return model, nil
+}
+
+// stage converts the recorded stage back, without its mask.
+func (s *SceneStage) stage() (Stage, error) {
+	var stage Stage
+	var err error
+	if stage.Mode, err = ParseShapeType(s.Mode); err != nil {
+		return stage, err
+	}
+	for _, name := range s.Modes {
+		t, err := ParseShapeType(name)
+		if err != nil {
+			return stage, err
+		}
+		stage.Modes = append(stage.Modes, t)
+	}
+	if stage.Blend, err = ParseBlendMode(s.Blend); err != nil {
+		return stage, err
+	}
+	if s.Search != nil {
+		stage.Search = *s.Search
+	}
+	stage.Count, stage.Alpha, stage.Repeat = s.Count, int(s.Alpha), s.Repeat
+	stage.MinSize, stage.MaxSize = s.MinSize, s.MaxSize
+	stage.MinAlpha, stage.MaxAlpha = s.MinAlpha, s.MaxAlpha
+	return stage, nil
+}
@@ -0,0 +1,77 @@
+package primitive
+
+import (
+	"bytes"
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// TestSceneRoundTrip writes each test run as a scene, reads it back and
+// checks that the rebuilt model has the same shapes, metadata and pixels.
+func TestSceneRoundTrip(t *testing.T) {
+	target := testTarget(t, 48)
+	for _, run := range testRuns {
+		model := testRun(t, target, run.options, 1)
+		var buf bytes.Buffer
+		if err := model.WriteScene(&buf); err != nil {
+			t.Fatalf("%s: %v", run.name, err)
+		}
+		scene, err := ReadScene(&buf)
+		if err != nil {
+			t.Fatalf("%s: %v", run.name, err)
+		}
+		rebuilt, err := scene.Model(model.Target, 0, 1)
+		if err != nil {
+			t.Fatalf("%s: %v", run.name, err)
+		}
+		if got, want := rebuilt.Scene(), model.Scene(); !reflect.DeepEqual(got.Shapes, want.Shapes) {
+			t.Errorf("%s: shapes\n%v\nwant\n%v", run.name, got.Shapes, want.Shapes)
+		}
+		if rebuilt.Background != model.Background || rebuilt.Sw != model.Sw || rebuilt.Sh != model.Sh {
+			t.Errorf("%s: background %v %dx%d, want %v %dx%d", run.name,
+				rebuilt.Background, rebuilt.Sw, rebuilt.Sh, model.Background, model.Sw, model.Sh)
+		}
+		if got, want := rebuilt.Meta, model.Meta; got.Seed != want.Seed || got.Antialias != want.Antialias ||
+			got.Tile != want.Tile || got.Pyramid != want.Pyramid || len(got.Stages) != len(want.Stages) {
+			t.Errorf("%s: metadata %+v, want %+v", run.name, got, want)
+		}
+		if !bytes.Equal(rebuilt.Current.Pix, model.Current.Pix) {
+			t.Errorf("%s: rebuilt pixels differ", run.name)
+		}
+		if math.Abs(rebuilt.Score-model.Score) > 1e-9 {
+			t.Errorf("%s: score %v, want %v", run.name, rebuilt.Score, model.Score)
+		}
+	}
+}
+
+func TestReadSceneErrors(t *testing.T) {
+	tests := []struct {
+		name  string
+		json  string
+		error string
+	}{
+		{"syntax", `{"version": 1,`, "unexpected EOF"},
+		{"old version", `{"version": 0, "width": 1, "height": 1}`, "unsupported scene version: 0"},
+		{"new version", `{"version": 2, "width": 1, "height": 1}`, "unsupported scene version: 2"},
+		{"no size", `{"version": 1}`, "scene has no size"},
+		{"background", `{"version": 1, "width": 1, "height": 1, "background": [1, 2]}`,
+			"background must have 3 or 4 values, got 2"},
+		{"shape type", `{"version": 1, "width": 1, "height": 1, "background": [0, 0, 0],
+			"shapes": [{"type": "star", "color": [0, 0, 0, 255], "params": [0, 0]}]}`, "shape 1: shape \"star\": unknown shape type"},
+		{"shape params", `{"version": 1, "width": 1, "height": 1, "background": [0, 0, 0],
+			"shapes": [{"type": "triangle", "color": [0, 0, 0, 255], "params": [0, 0]}]}`, "shape 1: triangle: expected 6 parameters, got 2"},
+		{"blend", `{"version": 1, "width": 1, "height": 1, "background": [0, 0, 0],
+			"shapes": [{"type": "rect", "color": [0, 0, 0, 255], "params": [0, 0, 1, 1], "blend": "add"}]}`, "shape 1: blend \"add\": unknown blend mode"},
+	}
+	for _, test := range tests {
+		scene, err := ReadScene(strings.NewReader(test.json))
+		if err == nil {
+			_, err = scene.Model(nil, 0, 1)
+		}
+		if err == nil || err.Error() != test.error {
+			t.Errorf("%s: error %v, want %q", test.name, err, test.error)
+		}
+	}
+}
@@ -1,9 +1,21 @@
 package primitive
 
-import "github.com/fogleman/gg"
+import (
+	"fmt"
+	"image"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/fogleman/gg"
+)
 
 type Shape interface {
 	Rasterize() []Scanline
+	// Bounds returns a rectangle of the worker's image holding every pixel
+	// that Rasterize may cover. It may be larger, never smaller, and is
+	// much cheaper to compute.
+	Bounds() image.Rectangle
 	Copy() Shape
 	Mutate()
 	Draw(dc *gg.Context, scale float64)
@@ -23,3 +35,184 @@ const (
 	ShapeTypeRotatedEllipse
 	ShapeTypePolygon
 )
+
+var shapeTypeNames = []string{
+	"combo", "triangle", "rect", "ellipse", "circle",
+	"rotatedrect", "beziers", "rotatedellipse", "polygon",
+}
+
+func (t ShapeType) String() string {
+	if t < 0 || int(t) >= len(shapeTypeNames) {
+		return fmt.Sprintf("ShapeType(%d)", int(t))
+	}
+	return shapeTypeNames[t]
+}
+
+// ParseShapeType accepts a shape type name or its -m number. Unknown types
+// are reported as a *ValidationError.
+func ParseShapeType(s string) (ShapeType, error) {
+	for i, name := range shapeTypeNames {
+		if s == name || s == strconv.Itoa(i) {
+			return ShapeType(i), nil
+		}
+	}
+	return 0, invalid("shape", s, "unknown shape type", suggest(s, shapeTypeNames))
+}
+
+// shapeTypeHint lists the shape types with their numbers.
+var shapeTypeHint = func() string {
+	var types []string
+	for i, name := range shapeTypeNames {
+		types = append(types, fmt.Sprintf("%d=%s", i, name))
+	}
+	return "use one of " + strings.Join(types, " ")
+}()
+
+// ShapeData returns the shape type and its parameters in target
+// coordinates: the vertices for triangles and polygons, the corners for
+// rectangles, center, radii (and angle in degrees) for ellipses, center,
+// size and angle for rotated rectangles and the control points and width
+// for beziers.
+func ShapeData(shape Shape) (ShapeType, []float64) {
+	switch s := shape.(type) {
+	case *Triangle:
+		return ShapeTypeTriangle, []float64{
+			float64(s.X1), float64(s.Y1), float64(s.X2), float64(s.Y2), float64(s.X3), float64(s.Y3)}
+	case *Rectangle:
+		x1, y1, x2, y2 := s.bounds()
+		return ShapeTypeRectangle, []float64{float64(x1), float64(y1), float64(x2), float64(y2)}
+	case *Ellipse:
+		t := ShapeTypeEllipse
+		if s.Circle {
+			t = ShapeTypeCircle
+		}
+		return t, []float64{float64(s.X), float64(s.Y), float64(s.Rx), float64(s.Ry)}
+	case *RotatedRectangle:
+		return ShapeTypeRotatedRectangle, []float64{
+			float64(s.X), float64(s.Y), float64(s.Sx), float64(s.Sy), float64(s.Angle)}
+	case *Quadratic:
+		return ShapeTypeQuadratic, []float64{s.X1, s.Y1, s.X2, s.Y2, s.X3, s.Y3, s.Width}
+	case *RotatedEllipse:
+		return ShapeTypeRotatedEllipse, []float64{s.X, s.Y, s.Rx, s.Ry, s.Angle}
+	case *Polygon:
+		var params []float64
+		for i := 0; i < s.Order; i++ {
+			params = append(params, s.X[i], s.Y[i])
+		}
+		return ShapeTypePolygon, params
+	}
+	return ShapeTypeAny, nil
+}
+
+// boundsOf returns the pixels of the worker's image in the box from x1, y1
+// to x2, y2, grown by a pixel on each side to allow for rounding. Boxes
+// that reach above the image span its whole width: the rasterizer rounds
+// y towards zero, so paths just above it add stray coverage to the first
+// row, which can run to either end of it.
+func boundsOf(worker *Worker, x1, y1, x2, y2 float64) image.Rectangle {
+	r := image.Rect(
+		int(math.Floor(x1))-1, int(math.Floor(y1))-1,
+		int(math.Ceil(x2))+2, int(math.Ceil(y2))+2)
+	if y1 < 0 {
+		r.Min.X, r.Max.X = 0, worker.W
+	}
+	return r.Intersect(image.Rect(0, 0, worker.W, worker.H))
+}
+
+// shapeFor returns a copy of shape that rasterizes and mutates with the
+// given worker. It reports false for shape types the package doesn't know.
+func shapeFor(shape Shape, worker *Worker) (Shape, bool) {
+	shape = shape.Copy()
+	switch s := shape.(type) {
+	case *Triangle:
+		s.Worker = worker
+	case *Rectangle:
+		s.Worker = worker
+	case *RotatedRectangle:
+		s.Worker = worker
+	case *Ellipse:
+		s.Worker = worker
+	case *RotatedEllipse:
+		s.Worker = worker
+	case *Quadratic:
+		s.Worker = worker
+	case *Polygon:
+		s.Worker = worker
+	default:
+		return nil, false
+	}
+	return shape, true
+}
+
+// transformShape rebuilds a shape for the given worker with its
+// coordinates scaled and then offset by dx, dy, and its sizes scaled.
+// Angles stay as they are. It reports false for shapes that NewShape can't
+// rebuild from their ShapeData, which are those of types the package
+// doesn't know.
+func transformShape(shape Shape, worker *Worker, scale, dx, dy float64) (Shape, bool) {
+	t, params := ShapeData(shape)
+	for i := range params {
+		switch {
+		case (t == ShapeTypeRotatedRectangle || t == ShapeTypeRotatedEllipse) && i == 4:
+			// angle
+		case (t == ShapeTypeEllipse || t == ShapeTypeCircle || t == ShapeTypeRotatedRectangle ||
+			t == ShapeTypeRotatedEllipse) && (i == 2 || i == 3), t == ShapeTypeQuadratic && i == 6:
+			params[i] *= scale // size
+		case i%2 == 0:
+			params[i] = params[i]*scale + dx
+		default:
+			params[i] = params[i]*scale + dy
+		}
+	}
+	result, err := NewShape(worker, t, params)
+	if err != nil {
+		debug("cannot transform shape", "error", err)
+		return nil, false
+	}
+	return result, true
+}
+
+// NewShape is the inverse of ShapeData: it builds a shape of the given type
+// from its parameters in target coordinates.
+func NewShape(worker *Worker, t ShapeType, params []float64) (Shape, error) {
+	count := map[ShapeType]int{
+		ShapeTypeTriangle:         6,
+		ShapeTypeRectangle:        4,
+		ShapeTypeEllipse:          4,
+		ShapeTypeCircle:           4,
+		ShapeTypeRotatedRectangle: 5,
+		ShapeTypeQuadratic:        7,
+		ShapeTypeRotatedEllipse:   5,
+	}
+	if n, ok := count[t]; ok && len(params) != n {
+		return nil, fmt.Errorf("%s: expected %d parameters, got %d", t, n, len(params))
+	}
+	p := params
+	i := func(k int) int { return int(math.Round(p[k])) }
+	switch t {
+	case ShapeTypeTriangle:
+		return &Triangle{worker, i(0), i(1), i(2), i(3), i(4), i(5)}, nil
+	case ShapeTypeRectangle:
+		return &Rectangle{worker, i(0), i(1), i(2), i(3)}, nil
+	case ShapeTypeEllipse, ShapeTypeCircle:
+		return &Ellipse{worker, i(0), i(1), i(2), i(3), t == ShapeTypeCircle}, nil
+	case ShapeTypeRotatedRectangle:
+		return &RotatedRectangle{worker, i(0), i(1), i(2), i(3), i(4)}, nil
+	case ShapeTypeQuadratic:
+		return &Quadratic{worker, p[0], p[1], p[2], p[3], p[4], p[5], p[6]}, nil
+	case ShapeTypeRotatedEllipse:
+		return &RotatedEllipse{worker, p[0], p[1], p[2], p[3], p[4]}, nil
+	case ShapeTypePolygon:
+		if len(p) < 6 || len(p)%2 != 0 {
+			return nil, fmt.Errorf("%s: expected an even number of at least 6 parameters, got %d", t, len(p))
+		}
+		order := len(p) / 2
+		x := make([]float64, order)
+		y := make([]float64, order)
+		for k := 0; k < order; k++ {
+			x[k], y[k] = p[2*k], p[2*k+1]
+		}
+		return &Polygon{worker, order, false, x, y}, nil
+	}
+	return nil, fmt.Errorf("unknown shape type: %d", int(t))
+}
@@ -0,0 +1,60 @@
+package primitive
+
+import (
+	"image"
+	"testing"
+)
+
+// TestBoundsContainRasterize checks that every shape type rasterizes
+// within its Bounds, which the search relies on to reject candidates
+// without rasterizing them, with and without antialiasing.
+func TestBoundsContainRasterize(t *testing.T) {
+	for _, antialias := range []bool{false, true} {
+		worker := NewWorker(image.NewRGBA(image.Rect(0, 0, 100, 80)))
+		worker.Antialias = antialias
+		worker.Rnd.Seed(1)
+		for st := ShapeTypeTriangle; st <= ShapeTypePolygon; st++ {
+			for i := 0; i < 500; i++ {
+				shape := worker.RandomState(st, 128).Shape
+				for j := 0; j < i%5; j++ {
+					shape.Mutate()
+				}
+				b := shape.Bounds()
+				for _, line := range shape.Rasterize() {
+					if line.X2 < line.X1 {
+						continue
+					}
+					if line.Y < b.Min.Y || line.Y >= b.Max.Y || line.X1 < b.Min.X || line.X2 >= b.Max.X {
+						t.Fatalf("%s (antialias %v): line %+v outside bounds %v %+v", st, antialias, line, b, shape)
+					}
+				}
+			}
+		}
+	}
+}
+
+// otherShape is a shape of a type the package doesn't know.
+type otherShape struct{ *Triangle }
+
+// TestTransformShape checks that every shape type can be moved to another
+// worker, and that a shape of an unknown type is reported instead of
+// panicking in the pyramid and tiled searches that move shapes.
+func TestTransformShape(t *testing.T) {
+	worker := NewWorker(image.NewRGBA(image.Rect(0, 0, 100, 80)))
+	worker.Rnd.Seed(1)
+	other := NewWorker(image.NewRGBA(image.Rect(0, 0, 200, 160)))
+	for st := ShapeTypeTriangle; st <= ShapeTypePolygon; st++ {
+		shape := worker.RandomState(st, 128).Shape
+		moved, ok := promote(shape, other)
+		if !ok {
+			t.Fatalf("%s: not promoted", st)
+		}
+		if got, _ := ShapeData(moved); got != st {
+			t.Errorf("promoted %s to %s", st, got)
+		}
+	}
+	triangle := worker.RandomState(ShapeTypeTriangle, 128).Shape.(*Triangle)
+	if _, ok := transformShape(otherShape{triangle}, other, 1, 10, 10); ok {
+		t.Error("transformed a shape of an unknown type")
+	}
+}
@@ -8,6 +8,9 @@ type State struct {
 	Score       float64
 }
 
+// NewState returns a state for a new shape. Alpha 0 lets hill climbing
+// mutate the alpha, starting from 128, and AlphaAuto fits it to each move
+// of the shape instead.
 func NewState(worker *Worker, shape Shape, alpha int) *State {
 	var mutateAlpha bool
 	if alpha == 0 {
@@ -24,6 +27,24 @@ func (state *State) Energy() float64 {
 	return state.Score
 }
 
+// EnergyBelow returns the energy, or a lower bound that is not below limit
+// if the shape can't get below it. Only an exact energy is kept.
+func (state *State) EnergyBelow(limit float64) float64 {
+	if state.Score < 0 {
+		energy := state.Worker.EnergyBelow(state.Shape, state.Alpha, limit)
+		if energy < limit {
+			state.Score = energy
+		}
+		return energy
+	}
+	return state.Score
+}
+
+// canceled reports whether the worker's step has been cancelled.
+func (state *State) canceled() bool {
+	return state.Worker.canceled()
+}
+
 func (state *State) DoMove() interface{} {
 	rnd := state.Worker.Rnd
 	oldState := state.Copy()
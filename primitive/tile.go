@@ -0,0 +1,246 @@
+package primitive
+
+import (
+	"context"
+	"image"
+	"sort"
+	"sync"
+)
+
+// Tiled runs split the working image into overlapping tiles so that large
+// targets can be worked on without giving every worker buffers the size of
+// the whole image. Each round, every tile searches for one shape on its
+// window, which is the tile plus an overlap on each side, against a copy of
+// the current image there, so it sees what its neighbors have drawn. Shapes
+// must lie entirely inside their window, never in pixels they were not
+// scored on. Tiles are colored like a 2x2 checkerboard: the tiles of one
+// color have windows that don't overlap, so they are searched in parallel
+// and their shapes added together, and the colors take turns so that each
+// sees the shapes added by the others. Every other round the grid is moved
+// by half a tile, so that no seam stays in the same place. Shapes are added
+// in a fixed order, color by color and by improvement within a color, which
+// makes the result independent of the number of workers.
+
+// tile is a window of the working image.
+type tile struct {
+	window image.Rectangle
+	inside image.Rectangle // where shapes must stay, in window coordinates
+	color  int
+}
+
+type tiler struct {
+	size, overlap int
+	workers       []map[image.Point]*Worker // per goroutine, by window size
+	rounds        int
+}
+
+// SetTiles enables tiled search with square tiles of the given size, in
+// working image pixels, searched by up to workers tiles at a time. 0
+// disables it.
+func (model *Model) SetTiles(size, workers int) {
+	model.tiles = nil
+	if size <= 0 {
+		return
+	}
+	t := &tiler{size: size, overlap: tileOverlap(size)}
+	for i := 0; i < maxInt(workers, 1); i++ {
+		t.workers = append(t.workers, make(map[image.Point]*Worker))
+	}
+	model.tiles = t
+}
+
+// tileOverlap returns the overlap on each side of tiles of the given size.
+func tileOverlap(size int) int {
+	return size / 4
+}
+
+// tileShapeSize returns the longest side a shape can have in a tiled search
+// with tiles of the given size: that of a window away from the image's
+// edges, less the pixel along each inner edge.
+func tileShapeSize(size int) int {
+	return size + 2*tileOverlap(size) - 2
+}
+
+// grid returns the tiles of a w x h image with the grid moved left and up
+// by offset.
+func (t *tiler) grid(w, h, offset int) []tile {
+	var tiles []tile
+	bounds := image.Rect(0, 0, w, h)
+	for ty, y := 0, -offset; y < h; ty, y = ty+1, y+t.size {
+		for tx, x := 0, -offset; x < w; tx, x = tx+1, x+t.size {
+			core := image.Rect(x, y, x+t.size, y+t.size).Intersect(bounds)
+			if core.Empty() {
+				continue
+			}
+			window := core.Inset(-t.overlap).Intersect(bounds)
+			inside := image.Rect(0, 0, window.Dx(), window.Dy())
+			// shapes may touch the window's edges only at the image's edges,
+			// as anything crossing an inner edge is cropped there
+			if window.Min.X > 0 {
+				inside.Min.X++
+			}
+			if window.Min.Y > 0 {
+				inside.Min.Y++
+			}
+			if window.Max.X < w {
+				inside.Max.X--
+			}
+			if window.Max.Y < h {
+				inside.Max.Y--
+			}
+			tiles = append(tiles, tile{window, inside, tx%2 + ty%2*2})
+		}
+	}
+	return tiles
+}
+
+// worker returns a worker of goroutine g for a window of the given size,
+// reusing its buffers from earlier rounds.
+func (t *tiler) worker(g int, size image.Point) *Worker {
+	worker, ok := t.workers[g][size]
+	if !ok {
+		worker = NewWorker(image.NewRGBA(image.Rectangle{Max: size}))
+		t.workers[g][size] = worker
+	}
+	return worker
+}
+
+// cropped returns the limits for a tile.
+func (l limits) cropped(window, inside image.Rectangle) limits {
+	if l.Mask != nil {
+		mask := image.NewGray(image.Rectangle{Max: window.Size()})
+		for y := 0; y < mask.Rect.Dy(); y++ {
+			copy(mask.Pix[mask.PixOffset(0, y):][:mask.Rect.Dx()],
+				l.Mask.Pix[l.Mask.PixOffset(window.Min.X, window.Min.Y+y):])
+		}
+		l.Mask = mask
+	}
+	l.Inside = inside
+	return l
+}
+
+// cropRGBA copies the window of im into dst, which has the window's size.
+func cropRGBA(dst, im *image.RGBA, window image.Rectangle) {
+	n := window.Dx() * 4
+	for y := 0; y < window.Dy(); y++ {
+		copy(dst.Pix[dst.PixOffset(0, y):][:n], im.Pix[im.PixOffset(window.Min.X, window.Min.Y+y):])
+	}
+}
+
+// tileProposal is the best shape a tile found, in image coordinates.
+type tileProposal struct {
+	index     int
+	state     *State
+	gain      float64 // squared error removed
+	evaluated int
+	rejected  int
+}
+
+// stepTiles runs one round of the tiled search, adding at most max shapes.
+// It returns the number of shapes added, which is 0 when no tile could
+// improve, the number of candidates evaluated and how many of them were
+// rejected by their bounds. added is called after
+// each shape is added with the candidates evaluated by its tile and how
+// many of them were rejected by their bounds.
+func (model *Model) stepTiles(ctx context.Context, stage Stage, search Search, l limits, max int, added func(n, rejected int)) (int, int, int, error) {
+	t := model.tiles
+	model.setLimits(l)
+	mode := stage.Mode
+	if len(stage.Modes) > 0 {
+		mode = ShapeTypeAny // picks from l.Types
+	}
+	size := model.Target.Bounds().Size()
+	tiles := t.grid(size.X, size.Y, t.rounds%2*t.size/2)
+	t.rounds++
+	base := model.Workers[0].Rnd.Int63()
+
+	count, evaluated, rejected := 0, 0, 0
+	for color := 0; color < 4 && count < max; color++ {
+		var jobs []int
+		for i, tile := range tiles {
+			if tile.color == color {
+				jobs = append(jobs, i)
+			}
+		}
+		proposals := make([]*tileProposal, len(jobs))
+		var next int
+		var mu sync.Mutex
+		var wg sync.WaitGroup
+		for g := range t.workers {
+			wg.Add(1)
+			go func(g int) {
+				defer wg.Done()
+				for {
+					mu.Lock()
+					k := next
+					next++
+					mu.Unlock()
+					if k >= len(jobs) {
+						return
+					}
+					model.acquire()
+					proposals[k] = model.searchTile(ctx, g, jobs[k], tiles[jobs[k]], l, base, mode, stage.Alpha, search)
+					model.release()
+				}
+			}(g)
+		}
+		wg.Wait()
+		if err := ctx.Err(); err != nil {
+			return count, evaluated, rejected, err
+		}
+
+		sort.SliceStable(proposals, func(a, b int) bool {
+			if proposals[a].gain != proposals[b].gain {
+				return proposals[a].gain > proposals[b].gain
+			}
+			return proposals[a].index < proposals[b].index
+		})
+		for _, p := range proposals {
+			evaluated += p.evaluated
+			rejected += p.rejected
+			if p.state == nil || p.gain <= 0 || count >= max {
+				continue
+			}
+			model.Add(p.state.Shape, p.state.Alpha)
+			count++
+			if added != nil {
+				added(p.evaluated, p.rejected)
+			}
+		}
+	}
+	return count, evaluated, rejected, nil
+}
+
+// searchTile finds the best shape for a tile and returns it in image
+// coordinates.
+func (model *Model) searchTile(ctx context.Context, g, index int, tile tile, l limits, base int64, t ShapeType, a int, search Search) *tileProposal {
+	worker := model.tiles.worker(g, tile.window.Size())
+	worker.Antialias = model.Workers[0].Antialias
+	cropRGBA(worker.Target, model.Target, tile.window)
+	current := worker.Current // left by the last tile of this size
+	if current == nil {
+		current = image.NewRGBA(worker.Target.Rect)
+	}
+	cropRGBA(current, model.Current, tile.window)
+	score := differenceFull(worker.Target, current)
+	worker.limits = l.cropped(tile.window, tile.inside)
+	worker.Rnd.Seed(base + int64(index))
+	worker.Init(current, score)
+	worker.ctx = ctx
+	state := worker.BestHillClimbState(t, a, search.Candidates, search.Age, search.Restarts)
+	worker.ctx = context.Background()
+
+	p := &tileProposal{index: index, evaluated: worker.Counter, rejected: worker.Rejected}
+	if state == nil || state.Energy() >= score {
+		return p
+	}
+	area := float64(tile.window.Dx() * tile.window.Dy())
+	p.gain = (score*score - state.Energy()*state.Energy()) * area
+	min := tile.window.Min
+	shape, ok := transformShape(state.Shape, model.Workers[0], 1, float64(min.X), float64(min.Y))
+	if !ok {
+		return p // the tile proposes nothing, as when it can't improve
+	}
+	p.state = &State{model.Workers[0], shape, state.Alpha, state.MutateAlpha, -1}
+	return p
+}
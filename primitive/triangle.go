@@ -2,7 +2,7 @@ package primitive
 
 import (
 	"fmt"
-	"math"
+	"image"
 
 	"github.com/fogleman/gg"
 )
@@ -130,11 +130,23 @@ func (t *Triangle) Valid() bool {
 }
 
 func (t *Triangle) Rasterize() []Scanline {
+	if t.Worker.Antialias {
+		return fillPolygon(t.Worker,
+			float64(t.X1), float64(t.Y1), float64(t.X2), float64(t.Y2), float64(t.X3), float64(t.Y3))
+	}
 	buf := t.Worker.Lines[:0]
 	lines := rasterizeTriangle(t.X1, t.Y1, t.X2, t.Y2, t.X3, t.Y3, buf)
 	return cropScanlines(lines, t.Worker.W, t.Worker.H)
 }
 
+func (t *Triangle) Bounds() image.Rectangle {
+	x1 := minInt(t.X1, minInt(t.X2, t.X3))
+	y1 := minInt(t.Y1, minInt(t.Y2, t.Y3))
+	x2 := maxInt(t.X1, maxInt(t.X2, t.X3))
+	y2 := maxInt(t.Y1, maxInt(t.Y2, t.Y3))
+	return boundsOf(t.Worker, float64(x1), float64(y1), float64(x2), float64(y2))
+}
+
 func rasterizeTriangle(x1, y1, x2, y2, x3, y3 int, buf []Scanline) []Scanline {
 	if y1 > y3 {
 		x1, x3 = x3, x1
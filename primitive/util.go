@@ -9,6 +9,7 @@ import (
 	"image/gif"
 	"image/jpeg"
 	"image/png"
+	"io"
 	"io/ioutil"
 	"math"
 	"os"
@@ -18,16 +19,18 @@ import (
 
 func LoadImage(path string) (image.Image, error) {
 	if path == "-" {
-		im, _, err := image.Decode(os.Stdin)
-		return im, err
+		return DecodeImage(os.Stdin)
 	} else {
 		file, err := os.Open(path)
 		if err != nil {
 			return nil, err
 		}
 		defer file.Close()
-		im, _, err := image.Decode(file)
-		return im, err
+		im, err := DecodeImage(file)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", path, err)
+		}
+		return im, nil
 	}
 }
 
@@ -61,10 +64,23 @@ func SaveJPG(path string, im image.Image, quality int) error {
 		return err
 	}
 	defer file.Close()
-	return jpeg.Encode(file, im, &jpeg.Options{quality})
+	return WriteJPG(file, im, quality)
+}
+
+func WriteJPG(w io.Writer, im image.Image, quality int) error {
+	return jpeg.Encode(w, im, &jpeg.Options{Quality: quality})
 }
 
 func SaveGIF(path string, frames []image.Image, delay, lastDelay int) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	return WriteGIF(file, frames, delay, lastDelay)
+}
+
+func WriteGIF(w io.Writer, frames []image.Image, delay, lastDelay int) error {
 	g := gif.GIF{}
 	for i, src := range frames {
 		dst := image.NewPaletted(src.Bounds(), palette.Plan9)
@@ -76,12 +92,7 @@ func SaveGIF(path string, frames []image.Image, delay, lastDelay int) error {
 			g.Delay = append(g.Delay, delay)
 		}
 	}
-	file, err := os.Create(path)
-	if err != nil {
-		return err
-	}
-	defer file.Close()
-	return gif.EncodeAll(file, &g)
+	return gif.EncodeAll(w, &g)
 }
 
 func SaveGIFImageMagick(path string, frames []image.Image, delay, lastDelay int) error {
@@ -108,6 +119,27 @@ func SaveGIFImageMagick(path string, frames []image.Image, delay, lastDelay int)
 	return os.RemoveAll(dir)
 }
 
+// WriteGIFImageMagick encodes the frames with ImageMagick into a temporary
+// file and copies the result to w.
+func WriteGIFImageMagick(w io.Writer, frames []image.Image, delay, lastDelay int) error {
+	dir, err := ioutil.TempDir("", "")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "output.gif")
+	if err := SaveGIFImageMagick(path, frames, delay, lastDelay); err != nil {
+		return err
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	_, err = io.Copy(w, file)
+	return err
+}
+
 func NumberString(x float64) string {
 	suffixes := []string{"", "k", "M", "G"}
 	for _, suffix := range suffixes {
@@ -189,32 +221,36 @@ func AverageImageColor(im image.Image) color.NRGBA {
 	rgba := imageToRGBA(im)
 	bounds := rgba.Bounds()
 	w, h := bounds.Dx(), bounds.Dy()
-	
+
 	// Direct access to pixel data for O(n) performance
 	pix := rgba.Pix
 	stride := rgba.Stride
 	minY := bounds.Min.Y
 	minX := bounds.Min.X
-	
-	var r, g, b int64
-	
+
+	var r, g, b, a int64
+
 	// Iterate through pixels in stride order for cache efficiency
 	for y := 0; y < h; y++ {
 		lineStart := (minY+y-bounds.Min.Y)*stride + (minX-bounds.Min.X)*4
 		for x := 0; x < w; x++ {
 			i := lineStart + x*4
-			r += int64(pix[i])     // R
-			g += int64(pix[i+1])   // G
-			b += int64(pix[i+2])   // B
-			// Skip alpha channel (pix[i+3])
+			r += int64(pix[i])   // R
+			g += int64(pix[i+1]) // G
+			b += int64(pix[i+2]) // B
+			a += int64(pix[i+3]) // A
 		}
 	}
-	
-	totalPixels := int64(w * h)
+
+	// the pixels are premultiplied, so dividing by the total alpha averages
+	// the visible colors and ignores transparent ones
+	if a == 0 {
+		return color.NRGBA{0, 0, 0, 255}
+	}
 	return color.NRGBA{
-		uint8(r / totalPixels),
-		uint8(g / totalPixels),
-		uint8(b / totalPixels),
+		uint8(r * 255 / a),
+		uint8(g * 255 / a),
+		uint8(b * 255 / a),
 		255,
 	}
 }
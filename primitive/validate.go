@@ -0,0 +1,198 @@
+package primitive
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// ValidationError reports an option or config value that cannot be used.
+// Field is the name of the value as in a pipeline file, Stage is the
+// 1-based stage it belongs to or 0 for run options, and Hint, if set,
+// suggests a fix.
+type ValidationError struct {
+	Stage  int
+	Field  string
+	Value  interface{}
+	Reason string
+	Hint   string
+}
+
+func (e *ValidationError) Error() string {
+	message := e.Field
+	if e.Value != nil {
+		message += fmt.Sprintf(" %#v", e.Value)
+	}
+	message += ": " + e.Reason
+	if e.Stage > 0 {
+		message = fmt.Sprintf("stage %d: %s", e.Stage, message)
+	}
+	return message
+}
+
+// ValidationErrors returns the validation errors in err, which may be a
+// single *ValidationError or several joined with errors.Join.
+func ValidationErrors(err error) []*ValidationError {
+	var result []*ValidationError
+	switch e := err.(type) {
+	case *ValidationError:
+		result = append(result, e)
+	case interface{ Unwrap() []error }:
+		for _, err := range e.Unwrap() {
+			result = append(result, ValidationErrors(err)...)
+		}
+	}
+	return result
+}
+
+func invalid(field string, value interface{}, reason, hint string) *ValidationError {
+	return &ValidationError{Field: field, Value: value, Reason: reason, Hint: hint}
+}
+
+// Validate checks the options before a run, reporting every problem found
+// joined together. Sizes that depend on the target are checked by Run.
+func (options *Options) Validate() error {
+	var errs []error
+	if options.InputSize < 0 && options.InputSize != InputSizeAuto {
+		errs = append(errs, invalid("input_size", options.InputSize, "must be >= 0", `use 0 to keep the input size, or "auto"`))
+	}
+	if options.Filter < FilterBilinear || options.Filter > FilterMitchell {
+		errs = append(errs, invalid("filter", int(options.Filter), "unknown resampling filter", "use one of "+strings.Join(filterNames, ", ")))
+	}
+	if options.Pyramid < 0 {
+		errs = append(errs, invalid("pyramid", options.Pyramid, "must be >= 0", "use 0 to search every shape at full resolution"))
+	}
+	if options.Refine < 0 {
+		errs = append(errs, invalid("refine", options.Refine, "must be >= 0", "use 0 to keep shapes as they were added"))
+	}
+	if options.RefineEvery < 0 {
+		errs = append(errs, invalid("refine_every", options.RefineEvery, "must be >= 0", "use 0 to refine only after the last stage"))
+	}
+	switch {
+	case options.Tile < 0 || (options.Tile > 0 && options.Tile < 32):
+		errs = append(errs, invalid("tile", options.Tile, "must be 0 or at least 32", "use 0 to search the whole image at once"))
+	case options.Tile > 0 && options.Pyramid > 0:
+		errs = append(errs, invalid("tile", options.Tile, "cannot be combined with pyramid", "use one or the other"))
+	case options.Tile > 0:
+		for _, stage := range options.Stages {
+			if stage.Repeat > 0 {
+				errs = append(errs, invalid("tile", options.Tile, "cannot be combined with repeat", "set repeat to 0"))
+				break
+			}
+		}
+		for i, stage := range options.Stages {
+			if max := tileShapeSize(options.Tile); stage.MinSize > max {
+				e := invalid("min_size", stage.MinSize, fmt.Sprintf("is larger than the %d pixels a shape can span with tile %d", max, options.Tile),
+					"use a larger tile, or no tile for stages of large shapes")
+				e.Stage = i + 1
+				errs = append(errs, e)
+			}
+		}
+	}
+	if options.OutputSize < 0 {
+		errs = append(errs, invalid("output_size", options.OutputSize, "must be >= 0", "use 0 for the default of 1024"))
+	}
+	if options.Workers < 0 {
+		errs = append(errs, invalid("workers", options.Workers, "must be >= 0", "use 0 for one worker per CPU"))
+	}
+	if err := options.Search.validate(); err != nil {
+		errs = append(errs, err)
+	}
+	if c := options.Background; c != nil && !c.valid() {
+		errs = append(errs, invalid("background", nil, "color channels must be between 0 and 255", ""))
+	}
+	if len(options.Stages) == 0 {
+		errs = append(errs, invalid("stages", nil, "at least one stage is required", ""))
+	}
+	for i := range options.Stages {
+		errs = append(errs, options.Stages[i].validate(i+1, 0, 0))
+	}
+	return errors.Join(errs...)
+}
+
+func (search Search) validate() error {
+	if search.Candidates < 0 || search.Age < 0 || search.Restarts < 0 {
+		return invalid("search", nil, "candidates, age and restarts must be >= 0", "use 0 for the defaults")
+	}
+	return nil
+}
+
+func (c Color) valid() bool {
+	for _, v := range []int{c.R, c.G, c.B, c.A} {
+		if v < 0 || v > 255 {
+			return false
+		}
+	}
+	return true
+}
+
+// ValidateOutput checks an output path before a run: its extension must
+// belong to a registered format, unless it has none, and its directory
+// must exist. "-" stands for stdout and is always valid.
+func ValidateOutput(path string) error {
+	if path == "-" {
+		return nil
+	}
+	if ext := filepath.Ext(path); ext != "" {
+		if _, ok := FormatForPath(path); !ok {
+			var exts []string
+			for ext := range extensions {
+				exts = append(exts, ext)
+			}
+			sort.Strings(exts) // so that ties go the same way every time
+			return invalid("output", path, "unrecognized file extension "+ext, suggest(strings.ToLower(ext), exts))
+		}
+	}
+	dir := filepath.Dir(path)
+	if info, err := os.Stat(dir); err != nil || !info.IsDir() {
+		return invalid("output", path, "directory "+dir+" does not exist", "create it first")
+	}
+	return nil
+}
+
+// suggest returns a hint for a value that is none of names: the closest
+// name when the value looks like a typo of it, or else the whole list.
+func suggest(value string, names []string) string {
+	best, distance := "", minInt(3, len(value))
+	for _, name := range names {
+		if d := editDistance(value, name); d < distance {
+			best, distance = name, d
+		}
+	}
+	if best != "" {
+		return fmt.Sprintf("did you mean %s?", best)
+	}
+	sorted := append([]string(nil), names...)
+	sort.Strings(sorted)
+	return "use one of " + strings.Join(sorted, ", ")
+}
+
+// editDistance returns the number of insertions, deletions, substitutions
+// and swaps of adjacent letters that turn a into b, each letter being
+// edited at most once (the optimal string alignment distance).
+func editDistance(a, b string) int {
+	older := make([]int, len(b)+1)
+	previous := make([]int, len(b)+1)
+	current := make([]int, len(b)+1)
+	for j := range previous {
+		previous[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		current[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			current[j] = minInt(minInt(previous[j]+1, current[j-1]+1), previous[j-1]+cost)
+			if i > 1 && j > 1 && a[i-1] == b[j-2] && a[i-2] == b[j-1] {
+				current[j] = minInt(current[j], older[j-2]+1)
+			}
+		}
+		older, previous, current = previous, current, older
+	}
+	return previous[len(b)]
+}
@@ -0,0 +1,98 @@
+package primitive
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+// TestSuggestions checks the hints of the parsers that take names: a close
+// typo suggests the name it was probably meant to be, anything else lists
+// the names.
+func TestSuggestions(t *testing.T) {
+	shape := func(s string) error { _, err := ParseShapeType(s); return err }
+	blend := func(s string) error { _, err := ParseBlendMode(s); return err }
+	filter := func(s string) error { _, err := ParseFilter(s); return err }
+	strategy := func(s string) error { _, err := ParsePruneStrategy(s); return err }
+	tests := []struct {
+		parse func(string) error
+		in    string
+		field string
+		hint  string
+	}{
+		{shape, "trangle", "shape", "did you mean triangle?"},
+		{shape, "elipse", "shape", "did you mean ellipse?"},
+		{shape, "hexagon", "shape", "use one of beziers, circle, combo, ellipse, polygon, rect, rotatedellipse, rotatedrect, triangle"},
+		{blend, "multipy", "blend", "did you mean multiply?"},
+		{blend, "overlay", "blend", "use one of multiply, normal, screen"},
+		{filter, "lanczoz", "filter", "did you mean lanczos?"},
+		{filter, "x", "filter", "use one of area, bilinear, lanczos, mitchell"},
+		{strategy, "greed", "strategy", "did you mean greedy?"},
+	}
+	for _, test := range tests {
+		var e *ValidationError
+		if err := test.parse(test.in); !errors.As(err, &e) {
+			t.Errorf("%q: error = %v, want a ValidationError", test.in, err)
+			continue
+		}
+		if e.Field != test.field || e.Hint != test.hint {
+			t.Errorf("%q: field %q hint %q, want %q %q", test.in, e.Field, e.Hint, test.field, test.hint)
+		}
+	}
+}
+
+func TestValidateOutput(t *testing.T) {
+	tests := []struct {
+		path string
+		hint string // "" when valid
+	}{
+		{"-", ""},
+		{"out.png", ""},
+		{"out", ""},
+		{"out.pgn", "did you mean .png?"},
+		{"out.svgz", "did you mean .svg?"},
+		{"missing/out.png", "create it first"},
+	}
+	for _, test := range tests {
+		err := ValidateOutput(test.path)
+		if test.hint == "" {
+			if err != nil {
+				t.Errorf("ValidateOutput(%q) = %v, want nil", test.path, err)
+			}
+			continue
+		}
+		var e *ValidationError
+		if !errors.As(err, &e) || e.Hint != test.hint {
+			t.Errorf("ValidateOutput(%q) = %#v, want hint %q", test.path, err, test.hint)
+		}
+	}
+}
+
+// TestValidateOptions checks that every problem is reported, each with its
+// stage.
+func TestValidateOptions(t *testing.T) {
+	options := Options{
+		InputSize: -2,
+		Tile:      16,
+		Stages: []Stage{
+			{Count: 10, Mode: ShapeTypeTriangle, Alpha: 128},
+			{Count: 0, Mode: ShapeTypeTriangle, Alpha: 300},
+		},
+	}
+	var got []string
+	for _, e := range ValidationErrors(options.Validate()) {
+		got = append(got, e.Error())
+	}
+	want := []string{
+		"input_size -2: must be >= 0",
+		"tile 16: must be 0 or at least 32",
+		"stage 2: count 0: must be > 0",
+		"stage 2: alpha 300: must be between 0 and 255",
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("got\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+	if err := (&Options{Stages: []Stage{{Count: 1, Alpha: 128}}}).Validate(); err != nil {
+		t.Errorf("valid options: %v", err)
+	}
+}
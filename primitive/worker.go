@@ -1,7 +1,9 @@
 package primitive
 
 import (
+	"context"
 	"image"
+	"math"
 	"math/rand"
 	"time"
 
@@ -19,6 +21,99 @@ type Worker struct {
 	Rnd        *rand.Rand
 	Score      float64
 	Counter    int
+	Rejected   int  // candidates rejected by their bounds, see EnergyBelow
+	Antialias  bool // see Model.SetAntialias
+	ctx        context.Context
+	limits     limits
+	levels     []*Worker // one per coarse pyramid level
+
+	// summed-area table of the squared error of Current, built on first
+	// use after Init, see lowerBound
+	residual      []uint64
+	residualValid bool
+}
+
+// limits restrict the shapes a worker proposes during a step, as set up by
+// the stage being run.
+type limits struct {
+	Types   []ShapeType
+	MinSize int
+	MaxSize int
+	Mask    *image.Gray
+	Blend   BlendMode
+	Inside  image.Rectangle // if not empty, shapes must lie within it
+
+	// the range for AlphaAuto, 0 for no bound
+	MinAlpha int
+	MaxAlpha int
+}
+
+// color returns the color of a shape with the given alpha over current,
+// choosing the alpha too when it is AlphaAuto.
+func (l *limits) color(target, current *image.RGBA, lines []Scanline, alpha int, antialias bool) Color {
+	if alpha == AlphaAuto {
+		lo, hi := maxInt(l.MinAlpha, 1), l.MaxAlpha
+		if hi == 0 {
+			hi = 255
+		}
+		alpha = computeAutoAlpha(target, current, lines, lo, hi, l.Blend)
+	}
+	return fitColor(target, current, lines, alpha, l.Blend, antialias)
+}
+
+// allow reports whether rasterized shape lines fit the size limits, lie
+// mostly inside the mask and entirely inside Inside.
+func (l *limits) allow(lines []Scanline) bool {
+	if !l.Inside.Empty() {
+		r := l.Inside
+		for _, line := range lines {
+			if line.Y < r.Min.Y || line.Y >= r.Max.Y || line.X1 < r.Min.X || line.X2 >= r.Max.X {
+				return false
+			}
+		}
+	}
+	if l.MinSize > 0 || l.MaxSize > 0 {
+		if len(lines) == 0 {
+			return false
+		}
+		size := linesSize(lines)
+		if size < l.MinSize || (l.MaxSize > 0 && size > l.MaxSize) {
+			return false
+		}
+	}
+	if l.Mask != nil {
+		var inside, total int
+		for _, line := range lines {
+			i := l.Mask.PixOffset(line.X1, line.Y)
+			for x := line.X1; x <= line.X2; x++ {
+				if l.Mask.Pix[i] >= 128 {
+					inside++
+				}
+				i++
+			}
+			total += line.X2 - line.X1 + 1
+		}
+		if inside*2 < total {
+			return false
+		}
+	}
+	return true
+}
+
+// linesSize returns the longest side of the bounding box of the lines.
+func linesSize(lines []Scanline) int {
+	if len(lines) == 0 {
+		return 0
+	}
+	x1, x2 := lines[0].X1, lines[0].X2
+	y1, y2 := lines[0].Y, lines[0].Y
+	for _, line := range lines {
+		x1 = minInt(x1, line.X1)
+		x2 = maxInt(x2, line.X2)
+		y1 = minInt(y1, line.Y)
+		y2 = maxInt(y2, line.Y)
+	}
+	return maxInt(x2-x1, y2-y1) + 1
 }
 
 func NewWorker(target *image.RGBA) *Worker {
@@ -33,6 +128,7 @@ func NewWorker(target *image.RGBA) *Worker {
 	worker.Lines = make([]Scanline, 0, 4096) // TODO: based on height
 	worker.Heatmap = NewHeatmap(w, h)
 	worker.Rnd = rand.New(rand.NewSource(time.Now().UnixNano()))
+	worker.ctx = context.Background()
 	return &worker
 }
 
@@ -40,28 +136,103 @@ func (worker *Worker) Init(current *image.RGBA, score float64) {
 	worker.Current = current
 	worker.Score = score
 	worker.Counter = 0
+	worker.Rejected = 0
+	worker.residualValid = false
 	worker.Heatmap.Clear()
 }
 
+// canceled reports whether the current step has been cancelled, in which
+// case the search loops return the best state found so far.
+func (worker *Worker) canceled() bool {
+	return worker.ctx.Err() != nil
+}
+
 func (worker *Worker) Energy(shape Shape, alpha int) float64 {
+	return worker.EnergyBelow(shape, alpha, math.Inf(1))
+}
+
+// EnergyBelow is Energy for a candidate that only matters if its energy is
+// below limit. When the error under the shape's bounds is too small for any
+// color to get there, it returns a lower bound that is not below limit
+// instead, without rasterizing the shape, and counts it in Rejected.
+func (worker *Worker) EnergyBelow(shape Shape, alpha int, limit float64) float64 {
 	worker.Counter++
+	if !math.IsInf(limit, 1) {
+		if bound := worker.lowerBound(shape.Bounds()); bound >= limit {
+			worker.Rejected++
+			return bound
+		}
+	}
 	lines := shape.Rasterize()
+	if !worker.limits.allow(lines) {
+		// worse than any shape that is allowed
+		return 1 + worker.Score
+	}
 	// worker.Heatmap.Add(lines)
-	color := computeColor(worker.Target, worker.Current, lines, alpha)
+	color := worker.limits.color(worker.Target, worker.Current, lines, alpha, worker.Antialias)
 	copyLines(worker.Buffer, worker.Current, lines)
-	drawLines(worker.Buffer, color, lines)
+	drawBlendLines(worker.Buffer, color, lines, worker.limits.Blend)
 	return differencePartial(worker.Target, worker.Current, worker.Buffer, worker.Score, lines)
 }
 
+// lowerBound returns the lowest score that drawing anything within r could
+// reach: the score with all of the error in r removed. It is computed the
+// way differencePartial computes scores, so that it is never above one.
+func (worker *Worker) lowerBound(r image.Rectangle) float64 {
+	if !worker.residualValid {
+		worker.buildResidual()
+	}
+	stride := worker.W + 1
+	t := worker.residual
+	sum := t[r.Max.Y*stride+r.Max.X] - t[r.Min.Y*stride+r.Max.X] -
+		t[r.Max.Y*stride+r.Min.X] + t[r.Min.Y*stride+r.Min.X]
+	n := worker.W * worker.H * 4
+	total := uint64(math.Pow(worker.Score*255, 2) * float64(n))
+	if sum >= total {
+		return 0
+	}
+	return math.Sqrt(float64(total-sum)/float64(n)) / 255
+}
+
+// buildResidual fills the summed-area table of the squared error between
+// Target and Current, with a row and a column of zeros before the first.
+func (worker *Worker) buildResidual() {
+	w, h := worker.W, worker.H
+	stride := w + 1
+	if len(worker.residual) != stride*(h+1) {
+		worker.residual = make([]uint64, stride*(h+1))
+	}
+	t := worker.residual
+	target, current := worker.Target.Pix, worker.Current.Pix
+	for y := 0; y < h; y++ {
+		var row uint64
+		i := worker.Target.PixOffset(0, y)
+		above, k := y*stride+1, (y+1)*stride+1
+		for x := 0; x < w; x++ {
+			dr := int(target[i]) - int(current[i])
+			dg := int(target[i+1]) - int(current[i+1])
+			db := int(target[i+2]) - int(current[i+2])
+			da := int(target[i+3]) - int(current[i+3])
+			row += uint64(dr*dr + dg*dg + db*db + da*da)
+			t[k+x] = t[above+x] + row
+			i += 4
+		}
+	}
+	worker.residualValid = true
+}
+
 func (worker *Worker) BestHillClimbState(t ShapeType, a, n, age, m int) *State {
 	var bestEnergy float64
 	var bestState *State
 	for i := 0; i < m; i++ {
+		if i > 0 && worker.canceled() {
+			break
+		}
 		state := worker.BestRandomState(t, a, n)
 		before := state.Energy()
 		state = HillClimb(state, age).(*State)
 		energy := state.Energy()
-		vv("%dx random: %.6f -> %dx hill climb: %.6f\n", n, before, age, energy)
+		debug("hill climb", "candidates", n, "random", before, "age", age, "score", energy)
 		if i == 0 || energy < bestEnergy {
 			bestEnergy = energy
 			bestState = state
@@ -74,8 +245,16 @@ func (worker *Worker) BestRandomState(t ShapeType, a, n int) *State {
 	var bestEnergy float64
 	var bestState *State
 	for i := 0; i < n; i++ {
+		if i > 0 && worker.canceled() {
+			break
+		}
 		state := worker.RandomState(t, a)
-		energy := state.Energy()
+		var energy float64
+		if i == 0 {
+			energy = state.Energy()
+		} else {
+			energy = state.EnergyBelow(bestEnergy)
+		}
 		if i == 0 || energy < bestEnergy {
 			bestEnergy = energy
 			bestState = state
@@ -87,6 +266,9 @@ func (worker *Worker) BestRandomState(t ShapeType, a, n int) *State {
 func (worker *Worker) RandomState(t ShapeType, a int) *State {
 	switch t {
 	default:
+		if types := worker.limits.Types; len(types) > 0 {
+			return worker.RandomState(types[worker.Rnd.Intn(len(types))], a)
+		}
 		return worker.RandomState(ShapeType(worker.Rnd.Intn(8)+1), a)
 	case ShapeTypeTriangle:
 		return NewState(worker, NewRandomTriangle(worker), a)
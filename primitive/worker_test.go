@@ -0,0 +1,74 @@
+package primitive
+
+import (
+	"image"
+	"math/rand"
+	"testing"
+)
+
+func randomRGBA(rnd *rand.Rand, w, h int) *image.RGBA {
+	im := image.NewRGBA(image.Rect(0, 0, w, h))
+	for i := 0; i < len(im.Pix); i += 4 {
+		im.Pix[i+0] = uint8(rnd.Intn(256))
+		im.Pix[i+1] = uint8(rnd.Intn(256))
+		im.Pix[i+2] = uint8(rnd.Intn(256))
+		im.Pix[i+3] = 255
+	}
+	return im
+}
+
+// TestResidual compares sums from the summed-area table with sums of the
+// squared error over the same rectangles.
+func TestResidual(t *testing.T) {
+	rnd := rand.New(rand.NewSource(1))
+	w, h := 37, 23
+	target, current := randomRGBA(rnd, w, h), randomRGBA(rnd, w, h)
+	worker := NewWorker(target)
+	worker.Init(current, differenceFull(target, current))
+	worker.buildResidual()
+	stride := w + 1
+	for k := 0; k < 200; k++ {
+		x1, x2 := rnd.Intn(w+1), rnd.Intn(w+1)
+		y1, y2 := rnd.Intn(h+1), rnd.Intn(h+1)
+		r := image.Rect(x1, y1, x2, y2)
+		var want uint64
+		for y := r.Min.Y; y < r.Max.Y; y++ {
+			for x := r.Min.X; x < r.Max.X; x++ {
+				i := target.PixOffset(x, y)
+				for c := 0; c < 4; c++ {
+					d := int(target.Pix[i+c]) - int(current.Pix[i+c])
+					want += uint64(d * d)
+				}
+			}
+		}
+		tbl := worker.residual
+		got := tbl[r.Max.Y*stride+r.Max.X] - tbl[r.Min.Y*stride+r.Max.X] -
+			tbl[r.Max.Y*stride+r.Min.X] + tbl[r.Min.Y*stride+r.Min.X]
+		if got != want {
+			t.Fatalf("sum over %v = %d, want %d", r, got, want)
+		}
+	}
+}
+
+// TestLowerBound checks that the bound of a shape's Bounds is never above
+// the score of adding it, for every shape type.
+func TestLowerBound(t *testing.T) {
+	rnd := rand.New(rand.NewSource(2))
+	w, h := 64, 48
+	target, current := randomRGBA(rnd, w, h), randomRGBA(rnd, w, h)
+	for _, antialias := range []bool{false, true} {
+		worker := NewWorker(target)
+		worker.Antialias = antialias
+		worker.Rnd.Seed(3)
+		worker.Init(current, differenceFull(target, current))
+		for st := ShapeTypeTriangle; st <= ShapeTypePolygon; st++ {
+			for i := 0; i < 200; i++ {
+				shape := worker.RandomState(st, 128).Shape
+				bound := worker.lowerBound(shape.Bounds())
+				if energy := worker.Energy(shape, 128); bound > energy+1e-12 {
+					t.Fatalf("%s (antialias %v): bound %v above score %v", st, antialias, bound, energy)
+				}
+			}
+		}
+	}
+}
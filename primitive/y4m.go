@@ -0,0 +1,201 @@
+package primitive
+
+import (
+	"bufio"
+	"fmt"
+	"image"
+	"io"
+	"math"
+
+	"github.com/fogleman/gg"
+)
+
+// Y4MWriter streams frames as uncompressed YUV4MPEG2 (4:2:0, BT.601) video.
+// Samples use the limited (video) range, which is what encoders and players
+// assume when the header doesn't say, and the header says so too. Width and
+// height are rounded down to even values.
+type Y4MWriter struct {
+	W, H   int
+	FPS    int
+	Frames int
+	writer *bufio.Writer
+	frame  []byte
+}
+
+func NewY4MWriter(w io.Writer, width, height, fps int) (*Y4MWriter, error) {
+	width &^= 1
+	height &^= 1
+	if width < 2 || height < 2 {
+		return nil, fmt.Errorf("invalid y4m frame size: %dx%d", width, height)
+	}
+	if fps < 1 {
+		return nil, fmt.Errorf("invalid y4m frame rate: %d", fps)
+	}
+	y := &Y4MWriter{}
+	y.W = width
+	y.H = height
+	y.FPS = fps
+	y.writer = bufio.NewWriter(w)
+	y.frame = make([]byte, width*height*3/2)
+	_, err := fmt.Fprintf(y.writer, "YUV4MPEG2 W%d H%d F%d:1 Ip A1:1 C420jpeg XCOLORRANGE=LIMITED\n", width, height, fps)
+	return y, err
+}
+
+func (y *Y4MWriter) WriteFrame(im image.Image) error {
+	rgba := imageToRGBA(im)
+	w, h := y.W, y.H
+	cw := w / 2
+	lum := y.frame[:w*h]
+	cb := y.frame[w*h : w*h+cw*h/2]
+	cr := y.frame[w*h+cw*h/2:]
+	for py := 0; py < h; py += 2 {
+		for px := 0; px < w; px += 2 {
+			var rs, gs, bs int
+			for dy := 0; dy < 2; dy++ {
+				i := rgba.PixOffset(px+rgba.Rect.Min.X, py+dy+rgba.Rect.Min.Y)
+				for dx := 0; dx < 2; dx++ {
+					r, g, b := rgba.Pix[i], rgba.Pix[i+1], rgba.Pix[i+2]
+					lum[(py+dy)*w+px+dx] = videoLuma(r, g, b)
+					rs += int(r)
+					gs += int(g)
+					bs += int(b)
+					i += 4
+				}
+			}
+			u, v := videoChroma(uint8(rs/4), uint8(gs/4), uint8(bs/4))
+			j := py/2*cw + px/2
+			cb[j] = u
+			cr[j] = v
+		}
+	}
+	return y.writeFrame()
+}
+
+// videoLuma returns the BT.601 luma of an sRGB color in the limited range
+// of 16 to 235.
+func videoLuma(r, g, b uint8) uint8 {
+	y := 0.299*float64(r) + 0.587*float64(g) + 0.114*float64(b)
+	return uint8(16 + math.Round(y*219/255))
+}
+
+// videoChroma returns the BT.601 chroma of an sRGB color in the limited
+// range of 16 to 240.
+func videoChroma(r, g, b uint8) (cb, cr uint8) {
+	u := -0.168736*float64(r) - 0.331264*float64(g) + 0.5*float64(b)
+	v := 0.5*float64(r) - 0.418688*float64(g) - 0.081312*float64(b)
+	return uint8(128 + math.Round(u*224/255)), uint8(128 + math.Round(v*224/255))
+}
+
+// Repeat writes the most recent frame n more times.
+func (y *Y4MWriter) Repeat(n int) error {
+	if y.Frames == 0 {
+		return nil
+	}
+	for i := 0; i < n; i++ {
+		if err := y.writeFrame(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (y *Y4MWriter) writeFrame() error {
+	if _, err := y.writer.WriteString("FRAME\n"); err != nil {
+		return err
+	}
+	if _, err := y.writer.Write(y.frame); err != nil {
+		return err
+	}
+	y.Frames++
+	return nil
+}
+
+func (y *Y4MWriter) Flush() error {
+	return y.writer.Flush()
+}
+
+// Video renders the model's shapes onto its own canvas as they are
+// accepted and streams every Nth shape to a Y4MWriter, so frames are
+// never collected in memory.
+type Video struct {
+	Writer     *Y4MWriter
+	Context    *gg.Context
+	Scale      float64
+	Nth        int
+	Drawn      int
+	pending    bool
+	background Color
+	refined    int // model.Meta.Refined when the canvas was drawn
+}
+
+// NewVideo creates a video of the model whose longest side is size pixels
+// (independent of the model output size) and writes the background frame.
+func (model *Model) NewVideo(w io.Writer, size, fps, nth int) (*Video, error) {
+	sw, sh, scale := outputSize(model.Target.Bounds().Dx(), model.Target.Bounds().Dy(), size)
+	writer, err := NewY4MWriter(w, sw, sh, fps)
+	if err != nil {
+		return nil, err
+	}
+	dc := gg.NewContext(writer.W, writer.H)
+	dc.Scale(scale, scale)
+	dc.Translate(0.5, 0.5)
+	dc.SetColor(model.Background.NRGBA())
+	dc.Clear()
+	if nth < 1 {
+		nth = 1
+	}
+	video := &Video{writer, dc, scale, nth, 0, false, model.Background, model.Meta.Refined}
+	return video, writer.WriteFrame(dc.Image())
+}
+
+// Update draws any shapes added to the model since the last call, writing
+// a frame after every Nth shape. When shapes already drawn have been
+// replaced by refinement or removed by pruning since, it first redraws
+// them from the background, and the next frame written shows the change.
+func (video *Video) Update(model *Model) error {
+	if len(model.Shapes) < video.Drawn || model.Meta.Refined != video.refined {
+		video.redraw(model, minInt(video.Drawn, len(model.Shapes)))
+	}
+	for video.Drawn < len(model.Shapes) {
+		model.drawShape(video.Context, video.Drawn, video.Scale)
+		video.Drawn++
+		video.pending = true
+		if video.Drawn%video.Nth == 0 {
+			if err := video.Writer.WriteFrame(video.Context.Image()); err != nil {
+				return err
+			}
+			video.pending = false
+		}
+	}
+	return nil
+}
+
+// redraw clears the canvas to the background and draws the first n shapes
+// of the model on it.
+func (video *Video) redraw(model *Model, n int) {
+	dc := video.Context
+	dc.SetColor(video.background.NRGBA())
+	dc.Clear()
+	for i := 0; i < n; i++ {
+		model.drawShape(dc, i, video.Scale)
+	}
+	video.Drawn = n
+	video.refined = model.Meta.Refined
+	video.pending = true
+}
+
+// Close writes the final frame if it has not been written yet, holds it
+// for the given number of seconds and flushes the stream.
+func (video *Video) Close(hold float64) error {
+	if video.pending {
+		if err := video.Writer.WriteFrame(video.Context.Image()); err != nil {
+			return err
+		}
+		video.pending = false
+	}
+	n := int(math.Round(hold * float64(video.Writer.FPS)))
+	if err := video.Writer.Repeat(n); err != nil {
+		return err
+	}
+	return video.Writer.Flush()
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/signal"
+
+	"github.com/fogleman/primitive/primitive"
+)
+
+func prune(args []string) {
+	flags := flag.NewFlagSet("prune", flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage: primitive prune [OPTIONS] -i scene.json -o output -n count")
+		fmt.Fprintln(os.Stderr, "       primitive prune [OPTIONS] -i scene.json -o output -loss 0.002")
+		flags.PrintDefaults()
+	}
+	input := flags.String("i", "", "JSON scene written by a previous run")
+	var outputs flagArray
+	flags.Var(&outputs, "o", "output path, in any output format")
+	target := flags.String("t", "", "target image (default uses the scene's input)")
+	count := flags.Int("n", 0, "remove shapes until this many remain")
+	loss := flags.Float64("loss", 0, "stop before the score rises by more than this")
+	strategy := flags.String("strategy", "greedy", "greedy ranks the shapes once, iterative after every removal")
+	size := flags.Int("s", 0, "output image size (default uses the scene's)")
+	verbose := flags.Bool("v", false, "verbose")
+	flags.Parse(args)
+
+	level := slog.LevelWarn
+	if *verbose {
+		level = slog.LevelInfo
+	}
+	slog.SetDefault(slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: level})))
+
+	ok := true
+	if *input == "" {
+		ok = errorMessage("ERROR: input argument required")
+	}
+	if len(outputs) == 0 {
+		ok = errorMessage("ERROR: output argument required")
+	}
+	for _, output := range outputs {
+		if err := primitive.ValidateOutput(output); err != nil {
+			ok = validationMessages(err, "", true)
+		}
+	}
+	options := primitive.PruneOptions{Count: *count, MaxLoss: *loss}
+	s, err := primitive.ParsePruneStrategy(*strategy)
+	if err != nil {
+		ok = validationMessages(err, "", true)
+	}
+	options.Strategy = s
+	if err := options.Validate(); err != nil {
+		ok = validationMessages(err, "", true)
+	}
+	if !ok {
+		flags.Usage()
+		os.Exit(1)
+	}
+
+	scene, err := primitive.LoadScene(*input)
+	check(err)
+	if *target == "" {
+		*target = scene.Input
+	}
+	if *target == "" {
+		check(fmt.Errorf("%s does not name its input; use -t", *input))
+	}
+	slog.Info("reading", "path", *target)
+	im, err := primitive.LoadImage(*target)
+	check(err)
+	model, err := scene.Model(im, *size, 1)
+	check(err)
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	before, shapes := model.Score, len(model.Shapes)
+	removed, err := model.Prune(ctx, options)
+	if err == context.Canceled {
+		slog.Warn("interrupted", "removed", removed)
+	} else {
+		check(err)
+	}
+	slog.Info("pruned", "shapes", shapes, "removed", removed, "score", before, "pruned", model.Score)
+
+	for _, output := range outputs {
+		saveOutput(model, output, len(model.Shapes))
+	}
+}
@@ -0,0 +1,650 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"image"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fogleman/primitive/primitive"
+)
+
+const (
+	jobQueued   = "queued"
+	jobRunning  = "running"
+	jobDone     = "done"
+	jobFailed   = "failed"
+	jobCanceled = "canceled"
+)
+
+// serverEvent is a single Server-Sent Event.
+type serverEvent struct {
+	Name string
+	Data string
+}
+
+type job struct {
+	ID       string
+	Status   string
+	Frame    int
+	Total    int // the most shapes the job can add, or those it added once done
+	Score    float64
+	Error    string
+	Created  time.Time
+	Started  time.Time
+	Finished time.Time
+
+	input   image.Image
+	options primitive.Options
+	preview string
+	every   int
+	ctx     context.Context
+	cancel  context.CancelFunc
+	model   *primitive.Model
+	last    *serverEvent
+	clients map[chan serverEvent]bool
+	mu      sync.Mutex
+}
+
+type jobStatus struct {
+	ID      string  `json:"id"`
+	Status  string  `json:"status"`
+	Frame   int     `json:"frame"`
+	Total   int     `json:"total"`
+	Score   float64 `json:"score"`
+	Elapsed float64 `json:"elapsed"`
+	Error   string  `json:"error,omitempty"`
+}
+
+func (j *job) status() jobStatus {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	var elapsed time.Duration
+	switch {
+	case !j.Finished.IsZero():
+		elapsed = j.Finished.Sub(j.Started)
+	case !j.Started.IsZero():
+		elapsed = time.Since(j.Started)
+	}
+	return jobStatus{
+		ID: j.ID, Status: j.Status, Frame: j.Frame, Total: j.Total,
+		Score: j.Score, Elapsed: elapsed.Seconds(), Error: j.Error}
+}
+
+// publish sends an event to every connected client, dropping it for
+// clients that are not keeping up. Final events are always delivered, in
+// place of the oldest pending event if a client's buffer is full, and close
+// the channel. It never blocks, as it holds j.mu.
+func (j *job) publish(e serverEvent, final bool) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if e.Name == "preview" {
+		j.last = &e
+	}
+	for ch := range j.clients {
+		select {
+		case ch <- e:
+		default:
+			if !final {
+				continue
+			}
+			// publish is the only sender, so after taking one event
+			// there is room for this one
+			select {
+			case <-ch:
+			default:
+			}
+			ch <- e
+		}
+		if final {
+			close(ch)
+			delete(j.clients, ch)
+		}
+	}
+}
+
+func (j *job) finished() bool {
+	return j.Status == jobDone || j.Status == jobFailed || j.Status == jobCanceled
+}
+
+type server struct {
+	jobs      map[string]*job
+	queue     chan *job
+	workers   int
+	slots     chan struct{} // shared by the running jobs' workers
+	every     int
+	ttl       time.Duration
+	maxBytes  int64
+	maxPixels int
+	mu        sync.Mutex
+}
+
+func serve(args []string) {
+	flags := flag.NewFlagSet("serve", flag.ExitOnError)
+	addr := flags.String("addr", ":8080", "address to listen on")
+	cpus := flags.Int("j", 0, "total number of workers shared by running jobs (default uses all cores)")
+	concurrent := flags.Int("jobs", 1, "number of jobs to run at once")
+	queue := flags.Int("queue", 16, "maximum number of queued jobs")
+	every := flags.Int("preview", 10, "default number of shapes between previews")
+	ttl := flags.Duration("ttl", time.Hour, "how long finished jobs are kept")
+	maxSize := flags.Int64("max", 32<<20, "maximum upload size in bytes")
+	maxPixels := flags.Int("max-pixels", 50000000, "maximum width times height of an uploaded image")
+	verbose := flags.Bool("v", false, "verbose")
+	flags.Parse(args)
+
+	level := slog.LevelWarn
+	if *verbose {
+		level = slog.LevelInfo
+	}
+	slog.SetDefault(slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: level})))
+
+	if *cpus < 1 {
+		*cpus = runtime.NumCPU()
+	}
+	if *concurrent < 1 {
+		*concurrent = 1
+	}
+	s := &server{
+		jobs:      make(map[string]*job),
+		queue:     make(chan *job, maxInt(*queue, 1)),
+		workers:   *cpus,
+		slots:     make(chan struct{}, *cpus),
+		every:     maxInt(*every, 1),
+		ttl:       *ttl,
+		maxBytes:  *maxSize,
+		maxPixels: *maxPixels,
+	}
+	for i := 0; i < *concurrent; i++ {
+		go s.run()
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /jobs", s.handleCreate)
+	mux.HandleFunc("GET /jobs/{id}", s.handleStatus)
+	mux.HandleFunc("DELETE /jobs/{id}", s.handleCancel)
+	mux.HandleFunc("GET /jobs/{id}/events", s.handleEvents)
+	mux.HandleFunc("GET /jobs/{id}/{file}", s.handleOutput)
+	mux.HandleFunc("GET /formats", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, primitive.Formats())
+	})
+	slog.Warn("listening", "addr", *addr, "jobs", *concurrent, "workers", s.workers)
+	check(http.ListenAndServe(*addr, mux))
+}
+
+func maxInt(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+func writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, code int, err error) {
+	writeJSON(w, code, map[string]string{"error": err.Error()})
+}
+
+func newJobID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// jobOptions builds run options from request parameters, which use the
+// same names as the command line flags.
+func jobOptions(values url.Values) (primitive.Options, error) {
+	var options primitive.Options
+	get := func(name string, value int) (int, error) {
+		s := values.Get(name)
+		if s == "" {
+			return value, nil
+		}
+		n, err := strconv.Atoi(s)
+		if err != nil {
+			return 0, fmt.Errorf("invalid %s: %q", name, s)
+		}
+		return n, nil
+	}
+	var err error
+	stage := primitive.Stage{}
+	var mode int
+	if stage.Count, err = get("n", 100); err != nil {
+		return options, err
+	}
+	if mode, err = get("m", 1); err != nil {
+		return options, err
+	}
+	stage.Mode = primitive.ShapeType(mode)
+	if values.Get("a") == "auto" {
+		stage.Alpha = primitive.AlphaAuto
+	} else if stage.Alpha, err = get("a", 128); err != nil {
+		return options, err
+	}
+	if stage.MinAlpha, err = get("min_alpha", 0); err != nil {
+		return options, err
+	}
+	if stage.MaxAlpha, err = get("max_alpha", 0); err != nil {
+		return options, err
+	}
+	if stage.Repeat, err = get("rep", 0); err != nil {
+		return options, err
+	}
+	if values.Get("r") == "auto" {
+		options.InputSize = primitive.InputSizeAuto
+	} else if options.InputSize, err = get("r", 256); err != nil {
+		return options, err
+	}
+	if options.Filter, err = primitive.ParseFilter(values.Get("filter")); err != nil {
+		return options, fmt.Errorf("invalid filter: %v", err)
+	}
+	if options.Pyramid, err = get("pyramid", 0); err != nil {
+		return options, err
+	}
+	if options.Tile, err = get("tile", 0); err != nil {
+		return options, err
+	}
+	if s := values.Get("aa"); s != "" {
+		if options.Antialias, err = strconv.ParseBool(s); err != nil {
+			return options, fmt.Errorf("invalid aa: %q", s)
+		}
+	}
+	if options.Refine, err = get("refine", 0); err != nil {
+		return options, err
+	}
+	if options.RefineEvery, err = get("refine_every", 0); err != nil {
+		return options, err
+	}
+	if options.OutputSize, err = get("s", 1024); err != nil {
+		return options, err
+	}
+	seed, err := get("seed", 0)
+	if err != nil {
+		return options, err
+	}
+	options.Seed = int64(seed)
+	if options.Background, err = primitive.ParseBackground(values.Get("bg")); err != nil {
+		return options, fmt.Errorf("invalid bg: %v", err)
+	}
+	options.Stages = []primitive.Stage{stage}
+	// name invalid options by their parameters
+	var messages []string
+	for _, e := range primitive.ValidationErrors(options.Validate()) {
+		if name, ok := flagNames[e.Field]; ok {
+			e.Field = name
+		}
+		e.Stage = 0
+		messages = append(messages, e.Error())
+	}
+	if len(messages) > 0 {
+		return options, errors.New(strings.Join(messages, "; "))
+	}
+	return options, nil
+}
+
+// handleCreate accepts an image either as the "image" field of a multipart
+// form or as the raw request body, with parameters in the query string or
+// form fields.
+func (s *server) handleCreate(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, s.maxBytes)
+	var input image.Image
+	var err error
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "multipart/form-data") {
+		file, _, ferr := r.FormFile("image")
+		if ferr != nil {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("missing image: %v", ferr))
+			return
+		}
+		defer file.Close()
+		input, err = primitive.DecodeImageLimit(file, s.maxPixels)
+	} else {
+		input, err = primitive.DecodeImageLimit(r.Body, s.maxPixels)
+	}
+	if err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("decoding image: %v", err))
+		return
+	}
+	r.ParseForm()
+	options, err := jobOptions(r.Form)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	every := s.every
+	if v := r.Form.Get("every"); v != "" {
+		if every, err = strconv.Atoi(v); err != nil || every < 1 {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("invalid every: %q", v))
+			return
+		}
+	}
+	preview := r.Form.Get("preview")
+	if preview == "" {
+		preview = "svg"
+	}
+	if preview != "svg" && preview != "png" && preview != "none" {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("preview must be svg, png or none"))
+		return
+	}
+
+	j := &job{
+		ID:      newJobID(),
+		Status:  jobQueued,
+		Created: time.Now(),
+		input:   input,
+		options: options,
+		preview: preview,
+		every:   every,
+		clients: make(map[chan serverEvent]bool),
+	}
+	for _, stage := range options.Stages {
+		j.Total += stage.Count * (1 + stage.Repeat)
+	}
+	// every job can use all the cores, which the running jobs share as
+	// their searches ask for them
+	j.options.Workers = s.workers
+	j.options.Slots = s.slots
+	j.ctx, j.cancel = context.WithCancel(context.Background())
+
+	s.mu.Lock()
+	select {
+	case s.queue <- j:
+		s.jobs[j.ID] = j
+		s.mu.Unlock()
+	default:
+		s.mu.Unlock()
+		j.cancel()
+		w.Header().Set("Retry-After", "10")
+		writeError(w, http.StatusServiceUnavailable, errors.New("job queue is full"))
+		return
+	}
+	slog.Info("queued", "job", j.ID, "n", j.Total)
+	w.Header().Set("Location", "/jobs/"+j.ID)
+	writeJSON(w, http.StatusAccepted, j.status())
+}
+
+func (s *server) run() {
+	for j := range s.queue {
+		s.runJob(j)
+	}
+}
+
+func (s *server) runJob(j *job) {
+	j.mu.Lock()
+	if j.Status != jobQueued {
+		j.mu.Unlock()
+		return
+	}
+	j.Status = jobRunning
+	j.Started = time.Now()
+	j.mu.Unlock()
+	slog.Info("running", "job", j.ID)
+
+	options := j.options
+	options.OnShape = func(e primitive.Event) {
+		j.mu.Lock()
+		j.Frame = e.Frame
+		j.Score = e.Score
+		j.mu.Unlock()
+
+		data, _ := json.Marshal(e)
+		j.publish(serverEvent{"progress", string(data)}, false)
+		if e.Frame%j.every == 0 {
+			if preview, ok := renderPreview(e.Model, j.preview); ok {
+				j.publish(preview, false)
+			}
+		}
+	}
+	model, err := primitive.Run(j.ctx, j.input, options)
+
+	j.mu.Lock()
+	j.model = model
+	j.input = nil
+	j.Finished = time.Now()
+	switch {
+	case err == nil:
+		j.Status = jobDone
+		j.Total = j.Frame // repeats may stop short
+	case errors.Is(err, context.Canceled):
+		j.Status = jobCanceled
+	default:
+		j.Status = jobFailed
+		j.Error = err.Error()
+	}
+	j.mu.Unlock()
+	j.cancel()
+
+	if model != nil {
+		if preview, ok := renderPreview(model, j.preview); ok {
+			j.publish(preview, false)
+		}
+	}
+	data, _ := json.Marshal(j.status())
+	j.publish(serverEvent{j.Status, string(data)}, true)
+	slog.Info("finished", "job", j.ID, "status", j.Status)
+	s.expire(j)
+}
+
+func renderPreview(model *primitive.Model, format string) (serverEvent, bool) {
+	switch format {
+	case "svg":
+		return serverEvent{"preview", model.SVG()}, true
+	case "png":
+		var buf bytes.Buffer
+		if err := primitive.Encode(&buf, "png", model, primitive.DefaultEncoderOptions()); err != nil {
+			return serverEvent{}, false
+		}
+		data := "data:image/png;base64," + base64.StdEncoding.EncodeToString(buf.Bytes())
+		return serverEvent{"preview", data}, true
+	}
+	return serverEvent{}, false
+}
+
+func (s *server) expire(j *job) {
+	time.AfterFunc(s.ttl, func() {
+		s.mu.Lock()
+		delete(s.jobs, j.ID)
+		s.mu.Unlock()
+	})
+}
+
+func (s *server) job(w http.ResponseWriter, r *http.Request) *job {
+	s.mu.Lock()
+	j, ok := s.jobs[r.PathValue("id")]
+	s.mu.Unlock()
+	if !ok {
+		writeError(w, http.StatusNotFound, errors.New("job not found"))
+		return nil
+	}
+	return j
+}
+
+func (s *server) handleStatus(w http.ResponseWriter, r *http.Request) {
+	if j := s.job(w, r); j != nil {
+		writeJSON(w, http.StatusOK, j.status())
+	}
+}
+
+// handleCancel stops a running job, which keeps its partial result, or
+// drops a queued one.
+func (s *server) handleCancel(w http.ResponseWriter, r *http.Request) {
+	j := s.job(w, r)
+	if j == nil {
+		return
+	}
+	j.mu.Lock()
+	queued := j.Status == jobQueued
+	if queued {
+		j.Status = jobCanceled
+		j.Finished = time.Now()
+	}
+	j.mu.Unlock()
+	j.cancel()
+	if queued {
+		data, _ := json.Marshal(j.status())
+		j.publish(serverEvent{jobCanceled, string(data)}, true)
+		s.expire(j)
+	}
+	writeJSON(w, http.StatusOK, j.status())
+}
+
+// handleEvents streams progress as Server-Sent Events: a "progress" event
+// per shape, a "preview" event every few shapes and a final event named
+// after the job's end status.
+func (s *server) handleEvents(w http.ResponseWriter, r *http.Request) {
+	j := s.job(w, r)
+	if j == nil {
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeError(w, http.StatusInternalServerError, errors.New("streaming unsupported"))
+		return
+	}
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+
+	ch := make(chan serverEvent, 64)
+	j.mu.Lock()
+	done := j.finished()
+	last := j.last
+	if !done {
+		j.clients[ch] = true
+	}
+	j.mu.Unlock()
+
+	status, _ := json.Marshal(j.status())
+	if done {
+		if last != nil {
+			writeEvent(w, *last)
+		}
+		writeEvent(w, serverEvent{j.status().Status, string(status)})
+		flusher.Flush()
+		return
+	}
+	writeEvent(w, serverEvent{"status", string(status)})
+	if last != nil {
+		writeEvent(w, *last)
+	}
+	flusher.Flush()
+
+	for {
+		select {
+		case e, ok := <-ch:
+			if !ok {
+				return
+			}
+			writeEvent(w, e)
+			flusher.Flush()
+		case <-r.Context().Done():
+			j.mu.Lock()
+			if j.clients[ch] {
+				delete(j.clients, ch)
+			}
+			j.mu.Unlock()
+			return
+		}
+	}
+}
+
+func writeEvent(w http.ResponseWriter, e serverEvent) {
+	fmt.Fprintf(w, "event: %s\n", e.Name)
+	for _, line := range strings.Split(e.Data, "\n") {
+		fmt.Fprintf(w, "data: %s\n", line)
+	}
+	fmt.Fprint(w, "\n")
+}
+
+var contentTypes = map[string]string{
+	"png":  "image/png",
+	"jpg":  "image/jpeg",
+	"gif":  "image/gif",
+	"svg":  "image/svg+xml",
+	"html": "text/html; charset=utf-8",
+	"json": "application/json",
+	"ora":  "image/openraster",
+	"dxf":  "image/vnd.dxf",
+	"y4m":  "video/x-yuv4mpeg",
+}
+
+// handleOutput encodes a finished (or cancelled) job's model in the format
+// given by the path extension or the "format" parameter, PNG by default.
+// It streams the output, so an error part way through can only be logged
+// and cuts the response short.
+func (s *server) handleOutput(w http.ResponseWriter, r *http.Request) {
+	j := s.job(w, r)
+	if j == nil {
+		return
+	}
+	name, format, _ := strings.Cut(r.PathValue("file"), ".")
+	if name != "output" {
+		writeError(w, http.StatusNotFound, errors.New("not found"))
+		return
+	}
+	if format == "" {
+		format = r.URL.Query().Get("format")
+	}
+	if format == "" {
+		format = "png"
+	}
+	if _, ok := primitive.EncoderFor(format); !ok {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("unrecognized output format: %s", format))
+		return
+	}
+	j.mu.Lock()
+	model := j.model
+	j.mu.Unlock()
+	if model == nil {
+		writeError(w, http.StatusConflict, errors.New("job has not finished"))
+		return
+	}
+	options := primitive.DefaultEncoderOptions()
+	options.Stages = model.Meta.StageEnds
+	contentType, ok := contentTypes[format]
+	if !ok {
+		contentType = "application/octet-stream"
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", j.ID+"."+format))
+	out := &countWriter{w: w}
+	if err := primitive.Encode(out, format, model, options); err != nil {
+		if out.n == 0 {
+			w.Header().Del("Content-Disposition")
+			writeError(w, http.StatusInternalServerError, err)
+			return
+		}
+		slog.Warn("writing output", "job", j.ID, "format", format, "error", err)
+	}
+}
+
+// countWriter counts the bytes written through it, to tell whether a
+// response has started.
+type countWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}